@@ -1,180 +1,225 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
-	"strconv"
-	"strings"
 
-	"github.com/loqutus/artifactory-replication/pkg/binary"
-	"github.com/loqutus/artifactory-replication/pkg/credentials"
-	"github.com/loqutus/artifactory-replication/pkg/docker"
-	"github.com/loqutus/artifactory-replication/pkg/ecr"
-	"github.com/loqutus/artifactory-replication/pkg/helm"
-	"github.com/loqutus/artifactory-replication/pkg/repos"
-	"github.com/loqutus/artifactory-replication/pkg/slack"
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/job"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/plan"
+	"github.com/loqutus/artifactory-replication/pkg/tunnel"
 )
 
+// exit codes
+const (
+	exitOK = 0
+	// exitFailed means the run finished, but some artifacts failed or the check found differences
+	exitFailed = 1
+	// exitConfig means the run didn't start because of a missing or wrong setting
+	exitConfig = 2
+	// exitError means the run was aborted by an error, e.g. a registry that can't be listed
+	exitError = 3
+)
+
+const usage = `usage: replicate [-config file] [-output table|json] [-save file] [-plan file]
+                 [-metrics-addr address] [-pushgateway url] [-metrics-file file]
+                 [-log-format text|logfmt|json] [-log-level debug|info|warn|error]
+                 [-listen address] [-api-token token] [-webhook-secret secret] [command]
+
+commands:
+  run       run the jobs, the default
+  validate  check the config and exit, without any network call
+  plan      print what the jobs would copy, overwrite, delete or skip, without changing anything
+  apply     run exactly the actions of the plan saved with plan -save, given with -plan
+  serve     run the jobs on their schedules until SIGTERM, serving their status and the control API on -listen
+
+Without -config or CONFIG_FILE, a single job is read from the env variables.
+`
+
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	sourceRegistry := os.Getenv("SOURCE_REGISTRY")
-	if sourceRegistry == "" {
-		panic("empty SOURCE_REGISTRY env variable")
-	}
-	destinationRegistry := os.Getenv("DESTINATION_REGISTRY")
-	if destinationRegistry == "" {
-		panic("empty DESTINATION_REGISTRY env variable")
-	}
-	artifactFilter := os.Getenv("ARTIFACT_FILTER")
-	artifactFilterProd := os.Getenv("ARTIFACT_FILTER_PROD")
-	artifactType := os.Getenv("ARTIFACT_TYPE")
-	destinationRegistryType := os.Getenv("DESTINATION_REGISTRY_TYPE")
-	force := os.Getenv("FORCE")
-	creds := credentials.Creds{
-		SourceUser:          os.Getenv("SOURCE_USER"),
-		SourcePassword:      os.Getenv("SOURCE_PASSWORD"),
-		DestinationUser:     os.Getenv("DESTINATION_USER"),
-		DestinationPassword: os.Getenv("DESTINATION_PASSWORD"),
-	}
-	checkReposFlag := os.Getenv("CHECK_REPOS")
-	if checkReposFlag == "true" {
-		if artifactType == "docker" || artifactType == "binary" {
-			repos.Check(sourceRegistry, destinationRegistry, creds, artifactType, destinationRegistryType, artifactFilter)
-		} else {
-			log.Println("unknown artifact type: ", artifactType)
-			os.Exit(1)
-		}
-		os.Exit(0)
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file with the jobs to run")
+	output := flag.String("output", "table", "plan output format, table or json")
+	saveFile := flag.String("save", "", "file to save the plan to, as JSON, for apply")
+	planFile := flag.String("plan", "", "saved plan file to apply")
+	metricsAddr := flag.String("metrics-addr", os.Getenv("METRICS_ADDR"), "address to serve Prometheus metrics on while running, e.g. :9090")
+	pushgateway := flag.String("pushgateway", os.Getenv("PUSHGATEWAY_URL"), "Pushgateway URL to push the metrics to at the end of the run")
+	pushJob := flag.String("pushgateway-job", envOr("PUSHGATEWAY_JOB", "artifactory-replication"), "job name of the metrics pushed to the Pushgateway")
+	metricsFile := flag.String("metrics-file", os.Getenv("METRICS_FILE"), "file to write the metrics to at the end of the run, in the Prometheus text format")
+	logFormat := flag.String("log-format", envOr("LOG_FORMAT", "text"), "log format, text, logfmt or json")
+	logLevel := flag.String("log-level", envOr("LOG_LEVEL", "info"), "minimum log level, debug, info, warn or error")
+	listen := flag.String("listen", envOr("LISTEN_ADDR", ":8080"), "address serve serves the job status and metrics on")
+	apiToken := flag.String("api-token", os.Getenv("API_TOKEN"), "bearer token the control API of serve requires, not required if empty")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("WEBHOOK_SECRET"), "shared secret of the webhooks serve receives on /hooks/, not received if empty")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
 	}
-	if artifactType == "docker" {
-		if artifactFilter != "" {
-			log.Println("Replicating docker images repo " + artifactFilter + " from " + sourceRegistry + " to " + destinationRegistry)
-		} else {
-			log.Println("Replicating docker images from " + sourceRegistry + " to " + destinationRegistry)
-		}
-		if destinationRegistryType == "aws" {
-			currentAccessKey := os.Getenv("AWS_ACCESS_KEY_ID")
-			if currentAccessKey == "" {
-				os.Setenv("AWS_ACCESS_KEY_ID", creds.DestinationUser)
-			}
-			currentSecretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-			if currentSecretKey == "" {
-				os.Setenv("AWS_SECRET_ACCESS_KEY", creds.DestinationPassword)
-			}
-			ECRLogin, ECRPassword, err := ecr.GetToken()
-			if err != nil {
-				panic(err)
-			}
-			creds.DestinationUser = ECRLogin
-			creds.DestinationPassword = ECRPassword
-		}
-		if destinationRegistryType != "azure" && destinationRegistryType != "aws" && destinationRegistryType != "alicloud" && destinationRegistryType != "google" {
-			if destinationRegistryType == "" {
-				destinationRegistryType = "azure"
-			} else {
-				panic("unknown DESTINATION_REGISTRY_TYPE")
-			}
-		}
-		docker.Replicate(creds, sourceRegistry, destinationRegistry, artifactFilter, destinationRegistryType)
-		if len(docker.FailedPushRepos) != 0 || len(docker.FailedPullRepos) != 0 || len(docker.FailedCleanRepos) != 0 {
-			log.Println("Failed docker operations:")
-			if len(docker.FailedPushRepos) != 0 {
-				log.Println("Docker push failed:")
-				log.Println(docker.FailedPushRepos)
-			}
-			if len(docker.FailedPullRepos) != 0 {
-				log.Println("Docker pull failed:")
-				log.Println(docker.FailedPullRepos)
-			}
-			if len(docker.FailedCleanRepos) != 0 {
-				log.Println("Docker clean failed:")
-				log.Println(docker.FailedCleanRepos)
-			}
-			os.Exit(1)
+	flag.Parse()
+	err := logger.Configure(*logFormat, *logLevel)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(exitConfig)
+	}
+	command := flag.Arg(0)
+	if command == "" {
+		command = "run"
+	}
+	if command != "run" && command != "validate" && command != "plan" && command != "apply" && command != "serve" {
+		flag.Usage()
+		os.Exit(exitConfig)
+	}
+	if *output != "table" && *output != "json" {
+		logger.Error("Unknown output format", "output", *output)
+		os.Exit(exitConfig)
+	}
+	if command == "apply" && *planFile == "" {
+		logger.Error("apply needs a saved plan, given with -plan")
+		os.Exit(exitConfig)
+	}
+	c, err := loadConfig(*configFile)
+	if err == nil {
+		for _, name := range c.IgnoredEnv() {
+			logger.Warn("Env variable ignored with several jobs, set JOB_<NAME>_"+name+" instead", "variable", name)
 		}
-	} else if artifactType == "binary" {
-		if destinationRegistryType != "s3" && destinationRegistryType != "artifactory" && destinationRegistryType != "oss" {
-			panic("unknown or empty DESTINATION_REGISTRY_TYPE")
+		err = c.Validate()
+	}
+	if err == nil {
+		err = httpclient.Configure(c)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, config.ErrInvalid) {
+			os.Exit(exitConfig)
 		}
-		if artifactFilterProd == "" {
-			binary.AlwaysSyncList = append(binary.AlwaysSyncList, "index.yaml")
+		os.Exit(exitError)
+	}
+	if command == "validate" {
+		logger.Info("Config is valid", "jobs", len(c.Jobs))
+		os.Exit(exitOK)
+	}
+	// the tunnel stays open until the process exits
+	if c.Tunnel.Host != "" {
+		_, err = tunnel.Start(c)
+		if err != nil {
+			logger.Error("Error starting jump host tunnel", "err", err)
+			os.Exit(exitError)
 		}
-		syncPattern := os.Getenv("SYNC_PATTERN")
-		if syncPattern != "" {
-			log.Println("Sync Pattern:", syncPattern)
+	}
+	if *metricsAddr != "" {
+		metrics.Serve(*metricsAddr)
+	}
+	if command == "serve" {
+		os.Exit(serve(c, *listen, *apiToken, *webhookSecret, func() { exportMetrics(*pushgateway, *pushJob, *metricsFile) }))
+	}
+	var outcomes []job.Outcome
+	switch command {
+	case "run":
+		outcomes = job.RunAll(c)
+	case "plan":
+		var p *plan.Plan
+		p, outcomes = job.PlanAll(c)
+		err = writePlan(p, *output, *saveFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(exitError)
 		}
-		helmCdnDomain := os.Getenv("HELM_CDN_DOMAIN")
-		if helmCdnDomain != "" {
-			log.Println("Helm CDN domain: " + helmCdnDomain)
+	case "apply":
+		p, err := plan.Load(*planFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(exitConfig)
 		}
-		binaryCleanup := os.Getenv("BINARY_CLEAN")
-		if binaryCleanup == "true" {
-			keepDaysString := os.Getenv("BINARY_CLEAN_KEEP_DAYS")
-			keepDays, err := strconv.Atoi(keepDaysString)
-			if err != nil {
-				log.Println("Error Atoi BINARY_CLEAN_KEEP_DAYS")
-				panic(err)
-			}
-			if helmCdnDomain == "" {
-				log.Println("Empty HELM_CDN_DOMAIN")
-				panic(nil)
-			}
-			binaryCleanPrefix := os.Getenv("BINARY_CLEAN_PREFIX")
-			if binaryCleanPrefix == "" {
-				log.Println("Empty BINARY_CLEAN_PREFIX")
-				panic(nil)
-			}
-			cleanedArtifacts, err := binary.Clean(destinationRegistry, destinationRegistryType, sourceRegistry, artifactFilterProd, creds, keepDays, helmCdnDomain, binaryCleanPrefix)
-			if err != nil {
-				log.Println("Error cleaning binary artifacts from " + destinationRegistry)
-				panic(err)
-			}
-			log.Println("Cleaned " + strconv.Itoa(len(cleanedArtifacts)) + " from " + destinationRegistry)
-			os.Exit(0)
+		outcomes = job.ApplyAll(c, p)
+	}
+	if command != "plan" {
+		exportMetrics(*pushgateway, *pushJob, *metricsFile)
+	}
+	code := exitOK
+	for _, outcome := range outcomes {
+		jobCode := exitCode(outcome)
+		if jobCode != exitOK {
+			logger.Warn("Job exited with an error", "job", outcome.Job, "code", jobCode)
 		}
-		log.Println("Replicating dev repo")
-		replicatedRealArtifacts, replicatedForcedArtifacts := binary.Replicate(creds, sourceRegistry, destinationRegistry, destinationRegistryType, artifactFilter, force, helmCdnDomain, syncPattern)
-		log.Printf("%d real artifacts copied to %s\n", len(replicatedRealArtifacts), artifactFilter)
-		log.Printf("%d forced artifacts copied to %s\n", len(replicatedForcedArtifacts), artifactFilter)
-		var replicatedRealArtifactsProd []string
-		var repoNameProd string
-		repoName := strings.Split(artifactFilter, "/")[0]
-		if len(artifactFilterProd) != 0 {
-			repoNameProd = strings.Split(artifactFilterProd, "/")[0]
+		if jobCode > code {
+			code = jobCode
 		}
-		if artifactFilterProd != "" {
-			log.Println("Replicating prod repo")
-			replicatedRealArtifactsProd, replicatedForcedArtifacts = binary.Replicate(creds, sourceRegistry, destinationRegistry, destinationRegistryType, artifactFilterProd, force, helmCdnDomain, syncPattern)
+	}
+	os.Exit(code)
+}
+
+// writePlan prints the plan to stdout and saves it as JSON if saveFile is set
+func writePlan(p *plan.Plan, output string, saveFile string) error {
+	var err error
+	if output == "json" {
+		err = p.WriteJSON(os.Stdout)
+	} else {
+		err = p.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if saveFile == "" {
+		return nil
+	}
+	f, err := os.Create(saveFile)
+	if err != nil {
+		return err
+	}
+	err = p.WriteJSON(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	logger.Info("Plan saved", "path", saveFile)
+	return f.Close()
+}
+
+// exportMetrics pushes the metrics to the Pushgateway and writes them to the file, if set.
+// Failing to export them doesn't change the exit code, the replication itself is done.
+func exportMetrics(pushgateway string, pushJob string, metricsFile string) {
+	if pushgateway != "" {
+		err := metrics.Push(pushgateway, pushJob)
+		if err != nil {
+			logger.Error("Error pushing metrics", "err", err)
 		}
-		if (len(replicatedRealArtifacts) != 0 || len(replicatedRealArtifactsProd) != 0) && artifactFilterProd != "" {
-			err := helm.RegenerateIndexYaml(replicatedRealArtifacts, replicatedRealArtifactsProd, sourceRegistry, destinationRegistry, repoName, repoNameProd, helmCdnDomain)
-			if err != nil {
-				log.Println("error regenerating index.yaml")
-				panic(err)
-			}
+	}
+	if metricsFile != "" {
+		err := metrics.WriteFile(metricsFile)
+		if err != nil {
+			logger.Error("Error writing metrics", "err", err)
 		}
-		if len(binary.FailedArtifactoryDownload) != 0 || len(binary.FailedS3Upload) != 0 {
-			if len(binary.FailedS3Upload) != 0 {
-				log.Println("S3 upload failed:")
-				log.Println(binary.FailedS3Upload)
-				err2 := slack.SendMessage("S3 upload failed")
-				if err2 != nil {
-					log.Println("slack.SendMessage failed")
-					log.Println(err2)
-				}
-			}
-			if len(binary.FailedArtifactoryDownload) != 0 {
-				log.Println("Failed artifactory download:")
-				log.Println(binary.FailedArtifactoryDownload)
-				err2 := slack.SendMessage("Artifactory download failed")
-				if err2 != nil {
-					log.Println("slack.SendMessage failed")
-					log.Println(err2)
-				}
-			}
-			os.Exit(1)
+	}
+}
+
+func envOr(name string, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+func loadConfig(configFile string) (*config.Config, error) {
+	if configFile == "" {
+		return config.FromEnv()
+	}
+	return config.Load(configFile)
+}
+
+func exitCode(outcome job.Outcome) int {
+	if outcome.Err != nil {
+		logger.Error(outcome.Err.Error(), "job", outcome.Job)
+		if errors.Is(outcome.Err, config.ErrInvalid) {
+			return exitConfig
 		}
-	} else {
-		panic("unknown or empty ARTIFACT_TYPE")
+		return exitError
+	}
+	if outcome.Result != nil && outcome.Result.HasFailures() {
+		return exitFailed
 	}
+	return exitOK
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/api"
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/job"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/scheduler"
+	"github.com/loqutus/artifactory-replication/pkg/webhook"
+)
+
+// serve runs the jobs on their schedules and on the events of their sources, serving their status, the control API and
+// the webhooks until SIGTERM or SIGINT, then waits for the running jobs to finish their transfers. A second signal exits right away.
+func serve(c *config.Config, listen string, apiToken string, webhookSecret string, export func()) int {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		logger.Error("Error listening", "address", listen, "err", err)
+		return exitError
+	}
+	s := scheduler.New(c)
+	s.AfterRun = func(job.Outcome) { export() }
+	mux := http.NewServeMux()
+	mux.Handle("/status", s)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", api.Handler(s, apiToken))
+	if webhookSecret != "" {
+		mux.Handle("/hooks/", webhook.Handler(s, c, webhookSecret))
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		err := server.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("Error serving status", "err", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	scheduled := s.Start()
+	if scheduled == 0 {
+		logger.Warn("No job has a schedule, nothing will run")
+	}
+	logger.Info("Serving", "address", ln.Addr().String(), "jobs", scheduled)
+	sig := <-signals
+	logger.Info("Shutting down, waiting for the running jobs", "signal", sig.String())
+	go func() {
+		sig := <-signals
+		logger.Warn("Exiting without waiting for the running jobs", "signal", sig.String())
+		os.Exit(exitError)
+	}()
+	s.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+	logger.Info("Shut down")
+	return exitOK
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/scheduler"
+)
+
+// keepAlive is how often an idle event stream gets a comment, so that proxies don't close it
+const keepAlive = 15 * time.Second
+
+// Handler serves the control API of the scheduler:
+//
+//	GET  /jobs                      state of every job
+//	POST /jobs/{name}/run           start a run, ?filter=, ?repo= and ?tag= narrow it to a path or repo prefix, a repo or a tag
+//	POST /jobs/{name}/check         start a check
+//	POST /jobs/{name}/clean         start a cleanup
+//	POST /jobs/{name}/plan          what a run would do, ?clean=true plans a cleanup
+//	GET  /runs                      recent runs with their result, ?job= for the runs of a job
+//	GET  /runs/{id}                 one run with its result
+//	GET  /runs/{id}/events          the steps of a run, as server-sent events
+//
+// With a token, requests must have an "Authorization: Bearer <token>" header.
+func Handler(s *scheduler.Scheduler, token string) http.Handler {
+	a := &api{scheduler: s, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", a.jobs)
+	mux.HandleFunc("/jobs/", a.job)
+	mux.HandleFunc("/runs", a.runs)
+	mux.HandleFunc("/runs/", a.run)
+	return a.authorize(mux)
+}
+
+type api struct {
+	scheduler *scheduler.Scheduler
+	token     string
+}
+
+func (a *api) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(a.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *api) jobs(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.scheduler.Status())
+}
+
+// job serves /jobs/{name}/{action}
+func (a *api) job(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found, expected /jobs/{name}/run, check, clean or plan"))
+		return
+	}
+	name, action := parts[0], parts[1]
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	q := r.URL.Query()
+	req := scheduler.Request{Kind: action, Filter: q.Get("filter"), Repo: q.Get("repo"), Tag: q.Get("tag")}
+	switch action {
+	case scheduler.KindRun, scheduler.KindCheck, scheduler.KindClean:
+		run, err := a.scheduler.Trigger(name, req)
+		if err != nil {
+			writeError(w, status(err), err)
+			return
+		}
+		w.Header().Set("Location", "/runs/"+strconv.Itoa(run.ID))
+		writeJSON(w, http.StatusAccepted, run)
+	case "plan":
+		req.Kind = scheduler.KindRun
+		if q.Get("clean") == "true" {
+			req.Kind = scheduler.KindClean
+		}
+		p, err := a.scheduler.Plan(name, req)
+		if err != nil {
+			writeError(w, status(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		p.WriteJSON(w)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q, expected run, check, clean or plan", action))
+	}
+}
+
+func (a *api) runs(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.scheduler.Runs(r.URL.Query().Get("job")))
+}
+
+// run serves /runs/{id} and /runs/{id}/events
+func (a *api) run(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 || len(parts) == 2 && parts[1] != "events" {
+		writeError(w, http.StatusNotFound, errors.New("not found, expected /runs/{id} or /runs/{id}/events"))
+		return
+	}
+	if len(parts) == 2 {
+		a.events(w, r, id)
+		return
+	}
+	run, ok := a.scheduler.FindRun(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %d not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// events streams the events of the run until it is done. A client reconnecting with Last-Event-ID
+// gets the events it missed, if they are still kept.
+func (a *api) events(w http.ResponseWriter, r *http.Request, id int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+	from := 0
+	if last, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		from = last + 1
+	}
+	events, done, changed, ok := a.scheduler.Events(id, from)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %d not found", id))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		for _, e := range events {
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			from = e.ID + 1
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-changed:
+		}
+		events, done, changed, ok = a.scheduler.Events(id, from)
+		if !ok {
+			return
+		}
+	}
+}
+
+// allow writes a 405 response if the request method isn't method
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed, expected %s", r.Method, method))
+	return false
+}
+
+// status returns the response status of the error
+func status(err error) int {
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownJob):
+		return http.StatusNotFound
+	case errors.Is(err, scheduler.ErrRunning):
+		return http.StatusConflict
+	case errors.Is(err, scheduler.ErrStopped):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, config.ErrInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
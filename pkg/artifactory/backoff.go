@@ -1,5 +0,0 @@
-package artifactory
-
-var backOffStart = 1000
-var backOffMultiplier = 3
-var backOffSteps = 5
@@ -3,66 +3,70 @@ package artifactory
 import (
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"regexp"
 	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
 func Download(fileURL string, helmCdnDomain string) (string, error) {
-	log.Println("Downloading " + fileURL)
-	var resp *http.Response
-	var failed bool
-	var err error
-	backOffTime := backOffStart
-	for i := 1; i <= backOffSteps; i++ {
-		resp, err = http.Get(fileURL)
-		if err != nil || resp.StatusCode != 200 {
-			failed = true
-			log.Println("error HTTP GET", resp.Status, fileURL, "retry", string(i))
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			defer resp.Body.Close()
-			failed = false
-			break
-		}
+	defer metrics.ObserveOperation("download", time.Now())
+	logger.Debug("Downloading", "url", fileURL)
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", err
 	}
-	if failed == true {
+	resp, err := retry.HTTP(httpclient.Client(), req)
+	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", retry.NewHTTPError(resp.StatusCode, "HTTP GET "+fileURL+" returned "+resp.Status)
+	}
 	tempFile, err := ioutil.TempFile("", "artifactory-download")
 	if err != nil {
 		return "", err
 	}
+	fileName := tempFile.Name()
 	_, err = io.Copy(tempFile, resp.Body)
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = rewriteIndex(fileName, fileURL, helmCdnDomain)
+	}
 	if err != nil {
+		os.Remove(fileName)
 		return "", err
 	}
-	fileName := tempFile.Name()
-	tempFile.Close()
+	return fileName, nil
+}
+
+// rewriteIndex replaces the Artifactory urls of a downloaded helm index.yaml with the CDN domain, if set
+func rewriteIndex(fileName string, fileURL string, helmCdnDomain string) error {
 	matched, err := regexp.MatchString("/index.yaml$", fileURL)
 	if err != nil {
-		return "", err
+		return err
 	}
-	if matched && helmCdnDomain != "" {
-		body, err := ioutil.ReadFile(fileName)
-		if err != nil {
-			return "", err
-		}
-		linkToReplace, err := regexp.Compile("(https?://.*?artifactory.*?/(artifactory/)?[^/]*?/)")
-		if err != nil {
-			return "", err
-		}
-		log.Println("Rewriting index.yaml urls...")
-		body = linkToReplace.ReplaceAll(body, []byte("https://"+helmCdnDomain+"/"))
-		err = ioutil.WriteFile(fileName, body, os.FileMode(0644))
-		if err != nil {
-			return "", err
-		}
+	if !matched || helmCdnDomain == "" {
+		return nil
 	}
-	return fileName, nil
+	body, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	linkToReplace, err := regexp.Compile("(https?://.*?artifactory.*?/(artifactory/)?[^/]*?/)")
+	if err != nil {
+		return err
+	}
+	logger.Debug("Rewriting index.yaml urls", "url", fileURL, "domain", helmCdnDomain)
+	body = linkToReplace.ReplaceAll(body, []byte("https://"+helmCdnDomain+"/"))
+	return ioutil.WriteFile(fileName, body, os.FileMode(0644))
 }
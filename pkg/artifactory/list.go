@@ -3,42 +3,37 @@ package artifactory
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"path"
 	"strings"
 	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
+// ListFiles returns the files and directories of dir, by their path relative to the repo root without a leading slash,
+// true for directories
 func ListFiles(host string, dir string, user string, pass string) (map[string]bool, error) {
-	url := "https://" + host + "/artifactory/api/storage/" + dir
-	client := &http.Client{}
+	defer metrics.ObserveOperation("list", time.Now())
+	url := httpclient.URL(host) + "/artifactory/api/storage/" + dir
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.SetBasicAuth(user, pass)
-	var resp *http.Response
-	var failed bool
-	backOffTime := backOffStart
-	for i := 1; i <= backOffSteps; i++ {
-		resp, err = client.Do(req)
-		if err != nil {
-			failed = true
-			log.Print("error HTTP GET ", url, " retry", string(i))
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			defer resp.Body.Close()
-			failed = false
-			break
-		}
-		defer resp.Body.Close()
-	}
-	if failed == true {
+	resp, err := retry.HTTP(httpclient.Client(), req)
+	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return make(map[string]bool), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, retry.NewHTTPError(resp.StatusCode, "HTTP GET "+url+" returned "+resp.Status)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -65,7 +60,8 @@ func ListFiles(host string, dir string, user string, pass string) (map[string]bo
 	}
 	var output = make(map[string]bool)
 	for _, file := range result.Children {
-		fileNameWithPath := strings.Trim(result.Path, "/") + file.URI
+		// relative to the repo root, the files of the root are listed with the path "/"
+		fileNameWithPath := strings.TrimPrefix(path.Join(result.Path, file.URI), "/")
 		output[fileNameWithPath] = file.Folder
 	}
 	return output, nil
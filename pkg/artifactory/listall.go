@@ -1,12 +1,13 @@
 package artifactory
 
 import (
-	"log"
 	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/logger"
 )
 
 func ListAllFiles(host string, dir string, user string, pass string) ([]string, error) {
-	log.Println("ListAllFiles: " + dir)
+	logger.Debug("Listing all files", "source", host, "repo", dir)
 	var outputFiles, outputDirs []string
 	files, err := ListFiles(host, dir, user, pass)
 	if err != nil {
@@ -21,7 +22,7 @@ func ListAllFiles(host string, dir string, user string, pass string) ([]string,
 	}
 	for len(outputDirs) > 0 {
 		fileNameWithDir := outputDirs[0]
-		//log.Println("listFiles: " + fileNameWithDir)
+		logger.Debug("Listing files", "source", host, "repo", fileNameWithDir)
 		files, err := ListFiles(host, fileNameWithDir, user, pass)
 		if err != nil {
 			return nil, err
@@ -33,12 +34,11 @@ func ListAllFiles(host string, dir string, user string, pass string) ([]string,
 			if !strings.HasPrefix(fileName, "/"+dir) {
 				fileName = dir + fileName
 			}
-			//log.Println("fileName: " + fileName)
 			if isDir == true {
-				//log.Println("Is Directory")
+				logger.Debug("Found directory", "source", host, "repo", fileName)
 				outputDirs = append(outputDirs, fileName)
 			} else {
-				//log.Println("Is File")
+				logger.Debug("Found file", "source", host, "file", fileName)
 				outputFiles = append(outputFiles, fileName)
 			}
 		}
@@ -3,40 +3,27 @@ package artifactory
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
 func GetArtifactoryFileSHA256(host string, fileName string, user string, pass string) (string, error) {
-	url := "https://" + host + "/artifactory/api/storage/" + fileName
-	client := &http.Client{}
+	url := httpclient.URL(host) + "/artifactory/api/storage/" + fileName
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", err
 	}
 	req.SetBasicAuth(user, pass)
-	var resp *http.Response
-	var failed bool
-	backOffTime := backOffStart
-	for i := 1; i <= backOffSteps; i++ {
-		resp, err = client.Do(req)
-		if err != nil {
-			failed = true
-			log.Print("error HTTP GET", url, "retry", string(i))
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			defer resp.Body.Close()
-			failed = false
-			break
-		}
-	}
-	if failed == true {
+	resp, err := retry.HTTP(httpclient.Client(), req)
+	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", retry.NewHTTPError(resp.StatusCode, "HTTP GET "+url+" returned "+resp.Status)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
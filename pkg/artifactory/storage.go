@@ -0,0 +1,207 @@
+package artifactory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// Storage stores binary artifacts in a repo of an artifactory instance
+type Storage struct {
+	Host     string
+	Repo     string
+	User     string
+	Password string
+}
+
+// NewStorage returns the storage of the repo, keys are paths inside the repo
+func NewStorage(host string, repo string, user string, pass string) *Storage {
+	return &Storage{Host: host, Repo: repo, User: user, Password: pass}
+}
+
+func (s *Storage) url(api string, key string) string {
+	url := httpclient.URL(s.Host) + "/artifactory/" + api + s.Repo
+	if key != "" {
+		url += "/" + strings.TrimPrefix(key, "/")
+	}
+	return url
+}
+
+// do sends the request with the storage credentials, retrying network errors and server errors
+func (s *Storage) do(req *http.Request) (*http.Response, error) {
+	req.SetBasicAuth(s.User, s.Password)
+	return retry.HTTP(httpclient.Client(), req)
+}
+
+func (s *Storage) send(method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(req)
+}
+
+// List returns the files under the prefix, with their sha256, using the deep file list API
+func (s *Storage) List(prefix string) ([]storage.Object, error) {
+	prefix = strings.Trim(prefix, "/")
+	resp, err := s.send(http.MethodGet, s.url("api/storage/", prefix)+"?list&deep=1&listFolders=0")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, retry.NewHTTPError(resp.StatusCode, "listing "+s.Repo+"/"+prefix+" returned "+resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		URI          string
+		Size         int64
+		LastModified string
+		Folder       bool
+		SHA2         string
+	}
+	type fileList struct {
+		Files []file
+	}
+	var result fileList
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+	var objects []storage.Object
+	for _, f := range result.Files {
+		if f.Folder {
+			continue
+		}
+		key := strings.TrimPrefix(prefix+f.URI, "/")
+		modified, _ := time.Parse(time.RFC3339, f.LastModified)
+		objects = append(objects, storage.Object{Key: key, Size: f.Size, Modified: modified, SHA256: f.SHA2})
+	}
+	return objects, nil
+}
+
+// Stat returns the file with the sha256 computed by artifactory
+func (s *Storage) Stat(key string) (storage.Object, error) {
+	resp, err := s.send(http.MethodGet, s.url("api/storage/", key))
+	if err != nil {
+		return storage.Object{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return storage.Object{}, storage.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return storage.Object{}, retry.NewHTTPError(resp.StatusCode, "getting info of "+s.Repo+"/"+key+" returned "+resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	type fileInfo struct {
+		Size         string
+		LastModified string
+		Checksums    map[string]string
+	}
+	var result fileInfo
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	size, _ := strconv.ParseInt(result.Size, 10, 64)
+	modified, _ := time.Parse(time.RFC3339, result.LastModified)
+	return storage.Object{Key: key, Size: size, Modified: modified, SHA256: result.Checksums["sha256"]}, nil
+}
+
+// SHA256 returns the checksum computed by artifactory
+func (s *Storage) SHA256(key string) (string, error) {
+	o, err := s.Stat(key)
+	if err != nil {
+		return "", err
+	}
+	if o.SHA256 == "" {
+		return "", fmt.Errorf("%w of %s/%s", storage.ErrNoChecksum, s.Repo, key)
+	}
+	return o.SHA256, nil
+}
+
+// Get downloads the file to the path
+func (s *Storage) Get(key string, path string) error {
+	resp, err := s.send(http.MethodGet, s.url("", key))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return storage.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return retry.NewHTTPError(resp.StatusCode, "downloading "+s.Repo+"/"+key+" returned "+resp.Status)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Put uploads the file with its sha256 checksum header, so artifactory verifies it
+func (s *Storage) Put(key string, path string) error {
+	fileSHA256, err := sha256.ComputeFileSHA256(path)
+	if err != nil {
+		return err
+	}
+	url := s.url("", key)
+	logger.Debug("Uploading", "url", url, "sha256", fileSHA256)
+	req, err := retry.NewFileRequest(http.MethodPut, url, path)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Checksum-Sha256", fileSHA256)
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return retry.NewHTTPError(resp.StatusCode, "uploading "+url+" returned "+resp.Status)
+	}
+	return nil
+}
+
+// Delete removes the file
+func (s *Storage) Delete(key string) error {
+	url := s.url("", key)
+	logger.Info("Removing", "url", url)
+	resp, err := s.send(http.MethodDelete, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return retry.NewHTTPError(resp.StatusCode, "removing "+url+" returned "+resp.Status)
+	}
+	return nil
+}
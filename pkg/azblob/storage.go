@@ -0,0 +1,250 @@
+package azblob
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+const apiVersion = "2019-12-12"
+
+// Storage stores binary artifacts as block blobs in an Azure Blob Storage container, using the REST API
+type Storage struct {
+	Account   string
+	Container string
+	// Endpoint is the blob service root, e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite
+	Endpoint string
+	sas      string
+	key      []byte
+}
+
+// NewStorage returns the storage of the container of the account. The credential is either a SAS token
+// or the base64 shared key of the account. The endpoint defaults to https://<account>.blob.core.windows.net.
+func NewStorage(account string, container string, credential string, endpoint string) (*Storage, error) {
+	if endpoint == "" {
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	s := &Storage{Account: account, Container: container, Endpoint: strings.TrimSuffix(endpoint, "/")}
+	credential = strings.TrimSpace(credential)
+	if isSAS(credential) {
+		s.sas = strings.TrimPrefix(credential, "?")
+		return s, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(credential)
+	if err != nil {
+		return nil, errors.New("azblob shared key of " + account + " is not base64 nor a SAS token: " + err.Error())
+	}
+	s.key = key
+	return s, nil
+}
+
+// url returns the URL of the blob, of the container when key is empty, with the query
+func (s *Storage) url(key string, query url.Values) string {
+	u := s.Endpoint + (&url.URL{Path: "/" + s.Container}).EscapedPath()
+	if key != "" {
+		u += (&url.URL{Path: "/" + key}).EscapedPath()
+	}
+	q := query.Encode()
+	if s.sas != "" {
+		if q != "" {
+			q += "&"
+		}
+		q += s.sas
+	}
+	if q != "" {
+		u += "?" + q
+	}
+	return u
+}
+
+// do sends the request built by newRequest, retrying network errors, 429 and 5xx responses.
+// The request is built and signed again for every attempt, so that its body can be read again.
+func (s *Storage) do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	var resp *http.Response
+	err := retry.Do(context.Background(), "calling azblob container "+s.Container, func() error {
+		req, err := newRequest()
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+		req.Header.Set("x-ms-version", apiVersion)
+		if s.sas == "" {
+			signSharedKey(req, s.Account, s.key)
+		}
+		r, err := httpclient.Client().Do(req)
+		if err != nil {
+			return err
+		}
+		if retry.RetryableStatus(r.StatusCode) {
+			return retry.StatusError(r)
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *Storage) send(method string, url string) (*http.Response, error) {
+	return s.do(func() (*http.Request, error) {
+		return http.NewRequest(method, url, nil)
+	})
+}
+
+// apiError returns the error of an unexpected response, with the error code of the REST API
+func apiError(action string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := action + " returned " + resp.Status
+	if code := resp.Header.Get("x-ms-error-code"); code != "" {
+		msg += " " + code
+	}
+	if len(body) != 0 {
+		msg += ": " + strings.TrimSpace(string(body))
+	}
+	return retry.NewHTTPError(resp.StatusCode, msg)
+}
+
+// blobList is the response of List Blobs
+type blobList struct {
+	Blobs []struct {
+		Name       string
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			ContentLength int64  `xml:"Content-Length"`
+		}
+		Metadata struct {
+			SHA256 string `xml:"sha256"`
+		}
+	} `xml:"Blobs>Blob"`
+	NextMarker string
+}
+
+// List returns the blobs under the prefix, with the sha256 stored by Put, following the markers
+func (s *Storage) List(prefix string) ([]storage.Object, error) {
+	var objects []storage.Object
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("restype", "container")
+		query.Set("comp", "list")
+		query.Set("include", "metadata")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := s.send(http.MethodGet, s.url("", query))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = apiError("listing "+s.Container+"/"+prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page blobList
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range page.Blobs {
+			modified, _ := http.ParseTime(b.Properties.LastModified)
+			objects = append(objects, storage.Object{Key: b.Name, Size: b.Properties.ContentLength, Modified: modified, SHA256: b.Metadata.SHA256})
+		}
+		if page.NextMarker == "" {
+			return objects, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+// Stat returns the blob with the sha256 stored in its metadata by Put
+func (s *Storage) Stat(key string) (storage.Object, error) {
+	resp, err := s.send(http.MethodHead, s.url(key, nil))
+	if err != nil {
+		return storage.Object{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return storage.Object{}, storage.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return storage.Object{}, apiError("getting properties of "+s.Container+"/"+key, resp)
+	}
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return storage.Object{
+		Key:      key,
+		Size:     resp.ContentLength,
+		Modified: modified,
+		SHA256:   resp.Header.Get("x-ms-meta-sha256"),
+	}, nil
+}
+
+// SHA256 returns the checksum stored in the blob metadata by Put
+func (s *Storage) SHA256(key string) (string, error) {
+	o, err := s.Stat(key)
+	if err != nil {
+		return "", err
+	}
+	if o.SHA256 == "" {
+		return "", fmt.Errorf("%w in metadata of %s/%s", storage.ErrNoChecksum, s.Container, key)
+	}
+	return o.SHA256, nil
+}
+
+// Get downloads the blob to the file path
+func (s *Storage) Get(key string, path string) error {
+	resp, err := s.send(http.MethodGet, s.url(key, nil))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return storage.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return apiError("downloading "+s.Container+"/"+key, resp)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Delete removes the blob, an already removed blob is not an error
+func (s *Storage) Delete(key string) error {
+	logger.Info("Removing", "destination", s.Container, "file", key)
+	resp, err := s.send(http.MethodDelete, s.url(key, nil))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		return apiError("removing "+s.Container+"/"+key, resp)
+	}
+	return nil
+}
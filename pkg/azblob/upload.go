@@ -0,0 +1,108 @@
+package azblob
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+)
+
+// blockSize of block blob uploads
+const blockSize = 8 * 1024 * 1024
+
+// Put uploads the file as a block blob, one block at a time, and commits the block list with the sha256 metadata
+func (s *Storage) Put(key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSHA256, err := sha256.ComputeFileSHA256(path)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Uploading", "destination", s.Container, "file", key, "sha256", fileSHA256)
+	size := info.Size()
+	// block ids must have the same length in a blob
+	var blockIDs []string
+	for offset := int64(0); offset < size; offset += blockSize {
+		n := size - offset
+		if n > blockSize {
+			n = blockSize
+		}
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", len(blockIDs))))
+		err := s.putBlock(key, blockID, io.NewSectionReader(f, offset, n), n)
+		if err != nil {
+			return err
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+	return s.putBlockList(key, blockIDs, fileSHA256)
+}
+
+func (s *Storage) putBlock(key string, blockID string, block *io.SectionReader, n int64) error {
+	query := url.Values{}
+	query.Set("comp", "block")
+	query.Set("blockid", blockID)
+	u := s.url(key, query)
+	resp, err := s.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, u, io.NewSectionReader(block, 0, n))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = n
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return apiError("uploading block of "+s.Container+"/"+key, resp)
+	}
+	return nil
+}
+
+// putBlockList commits the blocks, an empty list creates an empty blob
+func (s *Storage) putBlockList(key string, blockIDs []string, fileSHA256 string) error {
+	type blockList struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}
+	body, err := xml.Marshal(blockList{Latest: blockIDs})
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+	query := url.Values{}
+	query.Set("comp", "blocklist")
+	u := s.url(key, query)
+	resp, err := s.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("x-ms-meta-sha256", fileSHA256)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return apiError("committing blocks of "+s.Container+"/"+key, resp)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package azblob
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+)
+
+// fakeBlobs serves the block uploads and commits of a container, checking their shared key signature
+type fakeBlobs struct {
+	sync.Mutex
+	t      *testing.T
+	key    []byte
+	blocks map[string][]byte
+	blobs  map[string][]byte
+	meta   map[string]string
+	// order is the ids of the blocks in the order they were received
+	order []string
+}
+
+func (f *fakeBlobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	signed := r.Clone(r.Context())
+	signed.Header.Del("Authorization")
+	signSharedKey(signed, "devstoreaccount1", f.key)
+	if r.Header.Get("Authorization") != signed.Header.Get("Authorization") {
+		f.t.Errorf("wrong signature %q of %s %s, expected %q", r.Header.Get("Authorization"), r.Method, r.URL, signed.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPut || r.URL.Path != "/mycontainer/dir/file.tgz" {
+		f.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Query().Get("comp") {
+	case "block":
+		id := r.URL.Query().Get("blockid")
+		f.blocks[id] = body
+		f.order = append(f.order, id)
+		w.WriteHeader(http.StatusCreated)
+	case "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		err := xml.Unmarshal(body, &list)
+		if err != nil {
+			f.t.Errorf("wrong block list: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var blob []byte
+		for _, id := range list.Latest {
+			block, ok := f.blocks[id]
+			if !ok {
+				w.Header().Set("x-ms-error-code", "InvalidBlockList")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			blob = append(blob, block...)
+		}
+		f.blobs["dir/file.tgz"] = blob
+		f.meta["dir/file.tgz"] = r.Header.Get("x-ms-meta-sha256")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		f.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestPut(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		size   int
+		blocks int
+	}{
+		{"empty", 0, 0},
+		{"one block", 1000, 1},
+		{"two blocks", blockSize + 1000, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			key, _ := base64.StdEncoding.DecodeString(devstoreKey)
+			f := &fakeBlobs{t: t, key: key, blocks: make(map[string][]byte), blobs: make(map[string][]byte), meta: make(map[string]string)}
+			server := httptest.NewServer(f)
+			defer server.Close()
+			s, err := NewStorage("devstoreaccount1", "mycontainer", devstoreKey, server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir, err := ioutil.TempDir("", "azblob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "file.tgz")
+			data := bytes.Repeat([]byte("0123456789"), tc.size/10+1)[:tc.size]
+			err = ioutil.WriteFile(path, data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.Put("dir/file.tgz", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.order) != tc.blocks {
+				t.Errorf("uploaded %d blocks, expected %d", len(f.order), tc.blocks)
+			}
+			for _, id := range f.order {
+				if len(id) != len(f.order[0]) {
+					t.Errorf("block ids %v of different lengths", f.order)
+				}
+			}
+			blob, ok := f.blobs["dir/file.tgz"]
+			if !ok {
+				t.Fatal("block list not committed")
+			}
+			if !bytes.Equal(blob, data) {
+				t.Errorf("committed %d bytes, expected %d", len(blob), len(data))
+			}
+			fileSHA256, _ := sha256.ComputeFileSHA256(path)
+			if f.meta["dir/file.tgz"] != fileSHA256 {
+				t.Errorf("stored sha256 %q, expected %q", f.meta["dir/file.tgz"], fileSHA256)
+			}
+		})
+	}
+}
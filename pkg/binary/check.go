@@ -1,76 +1,77 @@
 package binary
 
 import (
-	"log"
+	"fmt"
 	"strings"
 
 	"github.com/loqutus/artifactory-replication/pkg/artifactory"
+	"github.com/loqutus/artifactory-replication/pkg/config"
 	"github.com/loqutus/artifactory-replication/pkg/credentials"
-	"github.com/loqutus/artifactory-replication/pkg/s3"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/result"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
 )
 
-var destinationBinariesList map[string]bool
-var CheckFailed bool
-var CheckFailedList []string
+// CheckRepos records every source file missing at destination, or with a different sha256, as a failed item of the result
+func CheckRepos(job config.Job) (*result.Result, error) {
+	res := result.New()
+	defer res.Finish()
+	sourceRegistry := job.Source.Registry
+	destinationRegistry := job.Destination.Registry
+	st, err := NewStorage(job, job.Filter)
+	if err != nil {
+		return res, err
+	}
+	destinationBinariesList, err := st.List(repoPath(job.Filter))
+	if err != nil {
+		return res, fmt.Errorf("listing files of %s: %w", destinationRegistry, err)
+	}
+	err = checkDir(logger.Job(job), sourceRegistry, st, job.Creds(), job.Filter, storage.Index(destinationBinariesList), res)
+	return res, err
+}
 
-func CheckRepos(sourceRegistry string, destinationRegistry string, destinationRegistryType string, creds credentials.Creds, dir string) error {
-	log.Println("Getting source repos from: " + sourceRegistry)
+func checkDir(l *logger.Logger, sourceRegistry string, st storage.Storage, creds credentials.Creds, dir string, destinationBinariesList map[string]storage.Object, res *result.Result) error {
+	l.Info("Getting source files", "repo", dir)
 	sourceFilesWithDirs, err := artifactory.ListFiles(sourceRegistry, dir, creds.SourceUser, creds.SourcePassword)
 	if err != nil {
-		log.Println("artifactory.ListFiles failed")
-		return err
-	}
-	if len(destinationBinariesList) == 0 {
-		destinationBinariesList, err = s3.ListFiles(destinationRegistry)
-		if err != nil {
-			log.Println("s3.ListFiles failed")
-			return err
-		}
+		return fmt.Errorf("listing files of %s/%s: %w", sourceRegistry, dir, err)
 	}
 	for sourceFile, isDir := range sourceFilesWithDirs {
 		if isDir {
-			log.Println("Processing source dir: " + sourceFile)
+			l.Debug("Processing source dir", "repo", sourceFile)
 			fileNameSplit := strings.Split(sourceFile, "/")
 			fileNameWithoutRepo := fileNameSplit[len(fileNameSplit)-1]
-			CheckRepos(sourceRegistry, destinationRegistry, destinationRegistryType, creds, dir+"/"+fileNameWithoutRepo)
-		} else {
-			var found bool
-			for destinationBinary := range destinationBinariesList {
-				if destinationBinary == sourceFile {
-					log.Println("Found:", destinationBinary)
-					found = true
-					sourceSHA256, err := artifactory.GetArtifactoryFileSHA256(sourceRegistry, sourceFile, creds.SourceUser, creds.SourcePassword)
-					if err != nil {
-						log.Println("Error getting source file sha256: ", sourceFile)
-						log.Println(err)
-						CheckFailed = true
-						CheckFailedList = append(CheckFailedList, sourceFile)
-						continue
-					}
-					destinationSHA256, err := s3.GetSHA256(destinationRegistry, destinationBinary)
-					if err != nil {
-						log.Println("Error getting destination file sha256:", destinationBinary)
-						log.Println(err)
-						CheckFailed = true
-						CheckFailedList = append(CheckFailedList, destinationBinary)
-						continue
-					}
-					if sourceSHA256 != destinationSHA256 {
-						log.Println("SHA256 mismatch:", destinationBinary)
-						log.Println("Source SHA256:", sourceSHA256)
-						log.Println("Destination SHA256:", destinationSHA256)
-						CheckFailed = true
-						CheckFailedList = append(CheckFailedList, destinationBinary)
-						continue
-					}
-					break
-				}
-			}
-			if !found {
-				CheckFailed = true
-				log.Println("Not found:", sourceFile)
-				CheckFailedList = append(CheckFailedList, sourceFile)
+			err := checkDir(l, sourceRegistry, st, creds, dir+"/"+fileNameWithoutRepo, destinationBinariesList, res)
+			if err != nil {
+				return err
 			}
+			continue
+		}
+		destinationFile, found := destinationBinariesList[sourceFile]
+		if !found {
+			l.Warn("File not found at destination", "file", sourceFile)
+			res.AddMissing(sourceFile, "not found at destination")
+			continue
+		}
+		l.Debug("File found at destination", "file", sourceFile)
+		sourceSHA256, err := artifactory.GetArtifactoryFileSHA256(sourceRegistry, sourceFile, creds.SourceUser, creds.SourcePassword)
+		if err != nil {
+			l.Error("Error getting source file sha256", "file", sourceFile, "err", err)
+			res.AddFailed(sourceFile, "check", err)
+			continue
+		}
+		destinationSHA256 := destinationFile.SHA256
+		if destinationSHA256 == "" {
+			destinationSHA256, err = st.SHA256(sourceFile)
+		}
+		if err != nil {
+			l.Error("Error getting destination file sha256", "file", sourceFile, "err", err)
+			res.AddFailed(sourceFile, "check", err)
+			continue
+		}
+		if sourceSHA256 != destinationSHA256 {
+			l.Warn("SHA256 mismatch", "file", sourceFile, "sha256", sourceSHA256, "destination_sha256", destinationSHA256)
+			res.AddMissing(sourceFile, "sha256 mismatch")
 		}
 	}
 	return nil
@@ -1,89 +1,101 @@
 package binary
 
 import (
-	"errors"
-	"log"
+	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/loqutus/artifactory-replication/pkg/artifactory"
-	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/config"
 	"github.com/loqutus/artifactory-replication/pkg/helm"
-	"github.com/loqutus/artifactory-replication/pkg/s3"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/plan"
+	"github.com/loqutus/artifactory-replication/pkg/result"
 )
 
-func removeStringFromSlice(slice []string, s int) []string {
-	return append(slice[:s], slice[s+1:]...)
+// Clean removes files older than the job keep days under the job clean prefix from the destination, unless they are in the prod repo
+func Clean(job config.Job) (*result.Result, error) {
+	p := plan.New()
+	err := PlanClean(job, p)
+	if err != nil {
+		res := result.New()
+		res.Finish()
+		return res, err
+	}
+	return Apply(job, p.Job(job.Name))
 }
 
-func Clean(destinationRegistry string, destinationRegistryType string, sourceRegistry string, artifactFilterProd string, creds credentials.Creds, keepDays int, helmCdnDomain string, binaryCleanPrefix string) ([]string, error) {
-	log.Println("Cleaning repo " + destinationRegistry + " from files older than " + strconv.Itoa(keepDays) + " days and not in repo " + sourceRegistry + "/" + artifactFilterProd)
-	var filesToRemove []string
-	if destinationRegistryType != "s3" {
-		return nil, errors.New("Unknown destination registry type: " + destinationRegistryType)
+// PlanClean adds to p the destination files Clean would remove, and the ones it would keep with the reason
+func PlanClean(job config.Job, p *plan.Plan) error {
+	err := job.Validate()
+	if err != nil {
+		return err
 	}
-	log.Println("artifactory.ListAllFiles " + sourceRegistry + "/" + artifactFilterProd)
-	sourceFilesProd, err := artifactory.ListAllFiles(sourceRegistry, artifactFilterProd, creds.SourceUser, creds.SourcePassword)
+	destinationRegistry := job.Destination.Registry
+	sourceRegistry := job.Source.Registry
+	artifactFilterProd := job.FilterProd
+	creds := job.Creds()
+	keepDays := job.Clean.KeepDays
+	binaryCleanPrefix := job.Clean.Prefix
+	l := logger.Job(job)
+	l.Info("Planning clean of files not in prod", "keep_days", keepDays, "repo", artifactFilterProd)
+	st, err := NewStorage(job, job.Filter)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	log.Println("got " + string(strconv.Itoa(len(sourceFilesProd))) + " files from artifactory repo " + artifactFilterProd)
-	log.Println("s3.GetFilesModificationDate: " + destinationRegistry)
-	destinationFiles, err := s3.GetFilesModificationDate(destinationRegistry)
+	sourceFilesProd, err := artifactory.ListAllFiles(sourceRegistry, artifactFilterProd, creds.SourceUser, creds.SourcePassword)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("listing files of %s/%s: %w", sourceRegistry, artifactFilterProd, err)
 	}
-	log.Println("got " + string(strconv.Itoa(len(destinationFiles))) + " files with modification date from " + destinationRegistry)
-	var destinationFilesFiltered = make(map[string]*time.Time)
-	for destinationFileName, destinationFileModificationDate := range destinationFiles {
-		if strings.HasPrefix(destinationFileName, binaryCleanPrefix) {
-			destinationFilesFiltered[destinationFileName] = destinationFileModificationDate
-		}
+	l.Info("Found prod source files", "repo", artifactFilterProd, "count", len(sourceFilesProd))
+	destinationFiles, err := st.List(binaryCleanPrefix)
+	if err != nil {
+		return fmt.Errorf("listing files of %s: %w", destinationRegistry, err)
 	}
-	var excludedCounter int
-	// O(n*n+n)
+	l.Info("Found destination files", "count", len(destinationFiles))
+	prodFiles := make(map[string]bool)
 	for _, sourceFile := range sourceFilesProd {
-		for destinationFile, _ := range destinationFilesFiltered {
-			//log.Println(sourceFile, destinationFile)
-			if sourceFile == destinationFile {
-				excludedCounter++
-				//log.Println("exluding " + sourceFile + " from removal")
-				delete(destinationFilesFiltered, destinationFile)
-			}
-		}
+		prodFiles[sourceFile] = true
 	}
-	log.Println("Excluded " + strconv.Itoa(excludedCounter) + " from removal")
 	timeKeep := time.Now().AddDate(0, 0, -keepDays)
-	for fileName, modificationDate := range destinationFilesFiltered {
-		if modificationDate.Before(timeKeep) {
-			filesToRemove = append(filesToRemove, fileName)
+	for _, file := range destinationFiles {
+		fileName := file.Key
+		action := plan.Action{Job: job.Name, Name: fileName, Ref: fileName}
+		if prodFiles[fileName] {
+			action.Op, action.Reason = plan.OpSkip, "in prod"
+		} else if file.Modified.Before(timeKeep) {
+			action.Op, action.Reason = plan.OpDelete, "older than "+strconv.Itoa(keepDays)+" days and not in prod"
+		} else {
+			action.Op, action.Reason = plan.OpSkip, "newer than "+strconv.Itoa(keepDays)+" days"
 		}
+		p.Add(action)
 	}
-	log.Println("removing " + strconv.Itoa(len(filesToRemove)) + " files from " + destinationRegistry)
-	removeFailed, err := s3.Delete(destinationRegistry, filesToRemove)
+	return nil
+}
+
+// applyDeletes removes the planned files from the destination and regenerates the helm indexes of their directories
+func applyDeletes(job config.Job, deletes []plan.Action, res *result.Result) error {
+	destinationRegistry := job.Destination.Registry
+	st, err := NewStorage(job, job.Filter)
 	if err != nil {
-		return nil, err
-	}
-	if len(removeFailed) > 0 {
-		log.Println("error removing files:")
-		for _, file := range removeFailed {
-			log.Println(file)
-		}
+		return err
 	}
-	var filesToReindex []string
-	for fileName, _ := range destinationFiles {
-		filesToReindex = append(filesToReindex, fileName)
-	}
-	if len(filesToRemove) > 0 {
-		err := helm.Reindex(filesToRemove, destinationRegistry, filesToReindex, helmCdnDomain)
+	var filesToRemove []string
+	l := logger.Job(job)
+	l.Info("Removing destination files", "count", len(deletes))
+	for _, a := range deletes {
+		err := st.Delete(a.Ref)
 		if err != nil {
-			log.Println("error regenerating index.yaml")
-			panic(err)
+			l.Error("Error removing file", "file", a.Ref, "err", err)
+			res.AddFailed(a.Ref, "delete", err)
+			continue
 		}
-	} else {
-		log.Println("Haven't found anything to remove, exiting...")
-		return nil, nil
+		filesToRemove = append(filesToRemove, a.Ref)
+		res.AddDeleted(result.Item{Name: a.Ref, Op: "delete", Reason: a.Reason})
+	}
+	err = helm.Reindex(st, filesToRemove, job.Binary.HelmCdnDomain)
+	if err != nil {
+		return fmt.Errorf("regenerating index.yaml in %s: %w", destinationRegistry, err)
 	}
-	return filesToRemove, nil
+	return nil
 }
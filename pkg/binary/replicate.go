@@ -1,154 +1,232 @@
 package binary
 
 import (
-	"log"
+	"fmt"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/loqutus/artifactory-replication/pkg/artifactory"
-	"github.com/loqutus/artifactory-replication/pkg/credentials"
-	"github.com/loqutus/artifactory-replication/pkg/oss"
-	"github.com/loqutus/artifactory-replication/pkg/s3"
-	"github.com/loqutus/artifactory-replication/pkg/slack"
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/plan"
+	"github.com/loqutus/artifactory-replication/pkg/pool"
+	"github.com/loqutus/artifactory-replication/pkg/result"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
 )
 
-func Replicate(creds credentials.Creds, sourceRegistry string, destinationRegistry string, destinationRegistryType string, sourceRepo string, force string, helmCdnDomain string, syncPattern string) ([]string, []string) {
-	var replicatedRealArtifacts, replicatedForcedArtifacts []string
-	log.Println("Replicating repo " + sourceRegistry + "/" + sourceRepo + " to " + destinationRegistry + "/" + sourceRepo)
-	sourceBinariesList, err := artifactory.ListFiles(sourceRegistry, sourceRepo, creds.SourceUser, creds.SourcePassword)
+// replication holds the parameters shared by the directory and file tasks of one Plan or Apply call
+type replication struct {
+	job              config.Job
+	alwaysSync       []string
+	destinationFiles map[string]storage.Object
+	workers          *pool.Pool
+	plan             *plan.Plan
+	res              *result.Result
+}
+
+func newReplication(job config.Job) *replication {
+	alwaysSync := AlwaysSyncList
+	if job.FilterProd == "" {
+		alwaysSync = append(alwaysSync[:len(alwaysSync):len(alwaysSync)], "index.yaml")
+	}
+	return &replication{
+		job:        job,
+		alwaysSync: alwaysSync,
+		workers:    pool.New(job.Concurrency, job.ConcurrencyPerHost),
+	}
+}
+
+// Replicate copies the files of sourceRepo, the job filter or prod filter, missing at destination, and the forced ones.
+// Copied items of the result are marked Forced unless they were missing at destination.
+func Replicate(job config.Job, sourceRepo string) (*result.Result, error) {
+	p := plan.New()
+	err := Plan(job, sourceRepo, p)
 	if err != nil {
-		err2 := slack.SendMessage(err.Error())
-		if err2 != nil {
-			log.Println(err)
-			panic(err2)
-		}
-		panic(err)
-	}
-	log.Println("Found source binaries:", len(sourceBinariesList))
-	endpoint := os.Getenv("OSS_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "oss-cn-beijing.aliyuncs.com"
-	}
-	if destinationRegistryType == "s3" {
-		if len(destinationBinariesList) == 0 {
-			destinationBinariesList, err = s3.ListFiles(destinationRegistry)
-			if err != nil {
-				err2 := slack.SendMessage(err.Error())
-				if err2 != nil {
-					log.Println(err)
-					panic(err2)
-				}
-				panic(err)
-			}
-			log.Println("Found destination binaries:", len(destinationBinariesList))
-		}
-	} else if destinationRegistryType == "artifactory" {
-		destinationBinariesList, err = artifactory.ListFiles(destinationRegistry, sourceRepo, creds.DestinationUser, creds.DestinationPassword)
-		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-				panic(err2)
-			}
-			panic(err)
-		}
-		log.Println("Found destination binaries:", len(destinationBinariesList))
-	} else if destinationRegistryType == "oss" {
-		destinationBinariesList, err = oss.ListFiles(destinationRegistry, creds, endpoint)
-		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-				panic(err2)
-			}
-			panic(err)
+		res := result.New()
+		res.Finish()
+		return res, err
+	}
+	return Apply(job, p.Job(job.Name))
+}
+
+// Plan adds to p the files of sourceRepo Replicate would copy, overwrite or skip, without downloading or uploading anything
+func Plan(job config.Job, sourceRepo string, p *plan.Plan) error {
+	err := job.Validate()
+	if err != nil {
+		return err
+	}
+	r := newReplication(job)
+	r.logger().Info("Planning replication", "repo", sourceRepo)
+	r.plan = p
+	st, err := NewStorage(job, sourceRepo)
+	if err != nil {
+		return err
+	}
+	destinationFiles, err := st.List(repoPath(sourceRepo))
+	if err != nil {
+		return fmt.Errorf("listing files of %s/%s: %w", job.Destination.Registry, sourceRepo, err)
+	}
+	r.logger().Info("Found destination files", "repo", sourceRepo, "count", len(destinationFiles))
+	r.destinationFiles = storage.Index(destinationFiles)
+	err = r.planDir(sourceRepo)
+	r.workers.Wait()
+	return err
+}
+
+// Apply copies the planned files of the job, without comparing source and destination again
+func Apply(job config.Job, actions []plan.Action) (*result.Result, error) {
+	res := result.New()
+	defer res.Finish()
+	err := job.Validate()
+	if err != nil {
+		return res, err
+	}
+	r := newReplication(job)
+	r.res = res
+	var deletes []plan.Action
+	for _, a := range actions {
+		a := a
+		switch a.Op {
+		case plan.OpCopy, plan.OpOverwrite:
+			r.workers.Go(r.hosts(), func() {
+				r.copyFile(a)
+			})
+		case plan.OpDelete:
+			deletes = append(deletes, a)
+		case plan.OpSkip:
+			res.AddSkipped(a.Name, a.Reason)
+		case plan.OpError:
+			res.AddFailed(a.Name, "list", fmt.Errorf("%s", a.Reason))
 		}
-		log.Println("Found destination binaries:", len(destinationBinariesList))
 	}
+	r.workers.Wait()
+	if len(deletes) != 0 {
+		err = applyDeletes(job, deletes, res)
+	}
+	return res, err
+}
+
+// logger returns a logger with the fields of the job
+func (r *replication) logger() *logger.Logger {
+	return logger.Job(r.job)
+}
+
+func (r *replication) hosts() []string {
+	return []string{r.job.Source.Registry, r.job.Destination.Registry}
+}
 
+// planDir submits a task for every file and subdirectory of the source directory.
+// Subdirectories failing to list are planned as errors, not returned.
+func (r *replication) planDir(sourceRepo string) error {
+	creds := r.job.Creds()
+	sourceBinariesList, err := artifactory.ListFiles(r.job.Source.Registry, sourceRepo, creds.SourceUser, creds.SourcePassword)
+	if err != nil {
+		return fmt.Errorf("listing files of %s/%s: %w", r.job.Source.Registry, sourceRepo, err)
+	}
+	r.logger().Info("Found source files", "repo", sourceRepo, "count", len(sourceBinariesList))
 	for fileName, fileIsDir := range sourceBinariesList {
+		fileName := fileName
 		if fileIsDir {
-			log.Println("Processing source dir: " + fileName)
+			r.logger().Debug("Processing source dir", "repo", fileName)
 			fileNameSplit := strings.Split(fileName, "/")
 			fileNameWithoutRepo := fileNameSplit[len(fileNameSplit)-1]
-			replicatedRealArtifactsTemp, replicatedForcedArtifactsTemp := Replicate(creds, sourceRegistry, destinationRegistry, destinationRegistryType, sourceRepo+"/"+fileNameWithoutRepo, force, helmCdnDomain, syncPattern)
-			for _, v := range replicatedRealArtifactsTemp {
-				replicatedRealArtifacts = append(replicatedRealArtifacts, v)
-			}
-			for _, v := range replicatedForcedArtifactsTemp {
-				replicatedForcedArtifacts = append(replicatedForcedArtifacts, v)
-			}
-		} else {
-			fileNameSplit := strings.Split(fileName, "/")
-			fileNameWithoutPath := fileNameSplit[len(fileNameSplit)-1]
-			fileURL := "http://" + sourceRegistry + "/artifactory/" + sourceRepo + "/" + fileNameWithoutPath
-			fileFound := false
-			for destinationFileName := range destinationBinariesList {
-				if destinationFileName == fileName {
-					//log.Println("Found binary in destination: " + destinationFileName)
-					fileFound = true
-					break
-				}
-			}
-			var doSync bool
-			if syncPattern != "" {
-				match, _ := regexp.MatchString(syncPattern, fileName)
-				if match {
-					doSync = true
-					log.Println("Filename", fileName, "matched pattern", syncPattern)
-				}
-
-			}
-			for _, st := range AlwaysSyncList {
-				if fileNameWithoutPath == st {
-					doSync = true
-					break
-				}
-			}
-			if !fileFound || doSync || force == "true" {
-				tempFileName, err := artifactory.Download(fileURL, helmCdnDomain)
+			dir := sourceRepo + "/" + fileNameWithoutRepo
+			r.workers.Go(r.hosts(), func() {
+				err := r.planDir(dir)
 				if err != nil {
-					log.Println("artifactory.Download failed:")
-					log.Println(err)
-					FailedArtifactoryDownload = append(FailedArtifactoryDownload, fileURL)
-					continue
+					r.logger().Error("Error listing source dir", "repo", dir, "err", err)
+					r.plan.Add(plan.Action{Job: r.job.Name, Op: plan.OpError, Name: dir, Reason: err.Error(), Repo: dir})
 				}
-				repoWithoutPathSplit := strings.Split(sourceRepo, "/")
-				repoWithoutPath := repoWithoutPathSplit[1]
-				destinationFileName := repoWithoutPath + "/" + fileName
-				destinationFileName = destinationFileName[strings.IndexByte(destinationFileName, '/'):]
-				log.Println("Dest: " + destinationFileName)
-				if destinationRegistryType == "s3" {
-					err := s3.Upload(destinationRegistry, destinationFileName, tempFileName)
-					if err != nil {
-						log.Println("s3.Upload failed:")
-						log.Println(err)
-						FailedS3Upload = append(FailedS3Upload, destinationFileName)
-						continue
-					}
-				} else if destinationRegistryType == "artifactory" {
-					err := artifactory.Upload(destinationRegistry, sourceRepo, fileName, creds.DestinationUser, creds.DestinationPassword, tempFileName)
-					if err != nil {
-						panic(err)
-					}
-				} else if destinationRegistryType == "oss" {
-					destinationFileName = strings.TrimPrefix(destinationFileName, "/")
-					err := oss.Upload(destinationRegistry, destinationFileName, creds, tempFileName, endpoint)
-					if err != nil {
-						panic(err)
-					}
-				}
-				fileNameSplit := strings.Split(fileName, "/")
-				fileNameWithoutRepo := fileNameSplit[len(fileNameSplit)-1]
-				if !doSync && !(force == "true") {
-					replicatedRealArtifacts = append(replicatedRealArtifacts, sourceRepo+"/"+fileNameWithoutRepo)
-				} else {
-					replicatedForcedArtifacts = append(replicatedForcedArtifacts, sourceRepo+"/"+fileNameWithoutRepo)
-				}
-				os.Remove(tempFileName)
-			}
+			})
+		} else {
+			r.planFile(sourceRepo, fileName)
 		}
 	}
-	return replicatedRealArtifacts, replicatedForcedArtifacts
+	return nil
+}
+
+func (r *replication) planFile(sourceRepo string, fileName string) {
+	fileNameSplit := strings.Split(fileName, "/")
+	fileNameWithoutPath := fileNameSplit[len(fileNameSplit)-1]
+	_, fileFound := r.destinationFiles[fileName]
+	action := plan.Action{Job: r.job.Name, Name: sourceRepo + "/" + fileNameWithoutPath, Repo: sourceRepo, Ref: fileName}
+	var doSync bool
+	reason := "not found at destination"
+	if r.job.Binary.Force {
+		reason = "forced"
+	}
+	if r.job.Binary.SyncPattern != "" {
+		match, _ := regexp.MatchString(r.job.Binary.SyncPattern, fileName)
+		if match {
+			doSync = true
+			reason = "matched SYNC_PATTERN"
+			r.logger().Debug("File matched sync pattern", "file", fileName, "pattern", r.job.Binary.SyncPattern)
+		}
+
+	}
+	for _, st := range r.alwaysSync {
+		if fileNameWithoutPath == st {
+			doSync = true
+			reason = "always synced"
+			break
+		}
+	}
+	switch {
+	case !fileFound:
+		action.Op = plan.OpCopy
+	case doSync || r.job.Binary.Force:
+		action.Op = plan.OpOverwrite
+	default:
+		action.Op, reason = plan.OpSkip, "present at destination"
+	}
+	action.Reason = reason
+	action.Forced = doSync || r.job.Binary.Force
+	r.plan.Add(action)
+}
+
+// copyFile downloads the planned file from artifactory and uploads it to the destination, recording it as copied or failed
+func (r *replication) copyFile(a plan.Action) {
+	started := time.Now()
+	sourceRepo, fileName := a.Repo, a.Ref
+	fileNameSplit := strings.Split(fileName, "/")
+	fileNameWithoutPath := fileNameSplit[len(fileNameSplit)-1]
+	// the files are downloaded over http unless the source scheme is set, as they always were
+	fileURL := "http://" + r.job.Source.Registry + "/artifactory/" + sourceRepo + "/" + fileNameWithoutPath
+	if r.job.Source.Scheme != "" {
+		fileURL = httpclient.URL(r.job.Source.Registry) + "/artifactory/" + sourceRepo + "/" + fileNameWithoutPath
+	}
+	l := r.logger().With("repo", sourceRepo, "file", fileName)
+	tempFileName, err := artifactory.Download(fileURL, r.job.Binary.HelmCdnDomain)
+	if err != nil {
+		l.Error("Artifactory download failed", "err", err)
+		r.res.AddFailed(fileURL, "download", err)
+		return
+	}
+	defer os.Remove(tempFileName)
+	var size int64
+	if info, err := os.Stat(tempFileName); err == nil {
+		size = info.Size()
+	}
+	l.Debug("Uploading file", "bytes", size)
+	st, err := NewStorage(r.job, sourceRepo)
+	if err == nil {
+		err = st.Put(fileName, tempFileName)
+	}
+	if err != nil {
+		l.Error("Upload failed", "err", err)
+		r.res.AddFailed(fileName, "upload", err)
+		return
+	}
+	r.res.AddCopied(result.Item{
+		Name:     a.Name,
+		Op:       "upload",
+		Reason:   a.Reason,
+		Forced:   a.Forced,
+		Bytes:    size,
+		Duration: time.Since(started),
+	})
+	l.Info("File copied", "bytes", size, "duration", time.Since(started))
 }
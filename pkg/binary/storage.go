@@ -0,0 +1,64 @@
+package binary
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/artifactory"
+	"github.com/loqutus/artifactory-replication/pkg/azblob"
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/filesystem"
+	"github.com/loqutus/artifactory-replication/pkg/gcs"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/oss"
+	"github.com/loqutus/artifactory-replication/pkg/s3"
+	"github.com/loqutus/artifactory-replication/pkg/sftp"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+const defaultOSSEndpoint = "oss-cn-beijing.aliyuncs.com"
+
+// NewStorage returns the destination storage of the job for the artifactory repo, e.g. the job filter.
+// Bucket destinations hold every repo, artifactory destinations are rooted at the repo with the same name.
+func NewStorage(job config.Job, repo string) (storage.Storage, error) {
+	st, err := newStorage(job, repo)
+	if err != nil {
+		return nil, err
+	}
+	return metrics.Storage(st), nil
+}
+
+func newStorage(job config.Job, repo string) (storage.Storage, error) {
+	creds := job.Creds()
+	switch job.Destination.Type {
+	case "s3":
+		return s3.NewStorage(job.Destination.Registry), nil
+	case "oss":
+		endpoint := job.Binary.OSSEndpoint
+		if endpoint == "" {
+			endpoint = defaultOSSEndpoint
+		}
+		return oss.NewStorage(job.Destination.Registry, creds, endpoint)
+	case "gcs":
+		return gcs.NewStorage(job.Destination.Registry, creds, job.Binary.GCSEndpoint), nil
+	case "azblob":
+		return azblob.NewStorage(creds.DestinationUser, job.Destination.Registry, creds.DestinationPassword, job.Binary.AzblobEndpoint)
+	case "filesystem":
+		return filesystem.NewStorage(job.Destination.Registry), nil
+	case "sftp":
+		return sftp.NewStorage(job.Destination.Registry, creds, job.Binary.SFTPKnownHosts), nil
+	case "artifactory":
+		repoKey := strings.Split(repo, "/")[0]
+		return artifactory.NewStorage(job.Destination.Registry, repoKey, creds.DestinationUser, creds.DestinationPassword), nil
+	}
+	return nil, fmt.Errorf("%w: unknown binary destination registry type %q", config.ErrInvalid, job.Destination.Type)
+}
+
+// repoPath returns the path of the artifactory repo dir inside the repo, which is the key prefix of its files
+func repoPath(repo string) string {
+	i := strings.IndexByte(repo, '/')
+	if i < 0 {
+		return ""
+	}
+	return strings.Trim(repo[i+1:], "/")
+}
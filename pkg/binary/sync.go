@@ -1,4 +1,4 @@
 package binary
 
+// AlwaysSyncList are the file names replicated even when present at destination
 var AlwaysSyncList = []string{"index.yaml.sha256", "get_kaas.sh"}
-var FailedArtifactoryDownload, FailedS3Upload []string
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/loqutus/artifactory-replication/pkg/credentials"
+)
+
+// Config is the replication config file, a list of jobs
+type Config struct {
+	// Parallel runs the jobs at the same time instead of one after another
+	Parallel bool  `json:"parallel"`
+	Jobs     []Job `json:"jobs"`
+	// ignoredEnv are the job env variables set without the JOB_<NAME>_ prefix, ignored with several jobs
+	ignoredEnv []string
+	// Tunnel is the SSH jump host the registries are reached through, not used if its host is empty
+	Tunnel Tunnel `json:"tunnel"`
+}
+
+// Tunnel is an SSH jump host
+type Tunnel struct {
+	// Host is the jump host, host[:port]
+	Host string `json:"host"`
+	User string `json:"user"`
+	// Key is the PEM private key, a path to it, or a secret reference
+	Key string `json:"key"`
+	// KnownHosts is the known hosts file the jump host key is verified with, ~/.ssh/known_hosts if not specified
+	KnownHosts string `json:"knownHosts"`
+	// Destination is the host:port reached through the jump host, the source hosts of the jobs if not specified
+	Destination string `json:"destination"`
+	// LocalPort also forwards this local port to Destination, for tools outside this process
+	LocalPort int `json:"localPort"`
+}
+
+// Job is one replication, check or cleanup between a source and a destination
+type Job struct {
+	Name string `json:"name"`
+	// Type is the artifact type, "docker" or "binary"
+	Type string `json:"type"`
+	// Check compares source and destination instead of replicating
+	Check       bool     `json:"check"`
+	Source      Registry `json:"source"`
+	Destination Registry `json:"destination"`
+	// Filter is the docker repo prefix or the binary repo path to replicate
+	Filter string `json:"filter"`
+	// FilterProd is the binary prod repo path, replicated after Filter and kept by cleanup
+	FilterProd string `json:"filterProd"`
+	Docker     Docker `json:"docker"`
+	Binary     Binary `json:"binary"`
+	Clean      Clean  `json:"clean"`
+	// Concurrency is the number of images or files replicated at once, 1 if not specified
+	Concurrency int `json:"concurrency"`
+	// ConcurrencyPerHost limits the images or files replicated at once from or to the same host, 0 means no limit
+	ConcurrencyPerHost int    `json:"concurrencyPerHost"`
+	Notify             Notify `json:"notify"`
+	// Schedule is when the serve command runs the job: a cron expression, e.g. "0 */6 * * *", or an interval, e.g. "@every 30m".
+	// Jobs without a schedule aren't run by serve.
+	Schedule string `json:"schedule"`
+	// Jitter delays every scheduled run by a random duration up to it, so that jobs on the same schedule don't start at once
+	Jitter Duration `json:"jitter"`
+	// Narrow limits a binary run to the files under this path of Filter or FilterProd, which are kept for the helm index.
+	// It is set by serve for the runs of its API and webhooks, never by the config.
+	Narrow string `json:"-"`
+}
+
+// Registry is a source or destination registry, bucket or artifactory host.
+// User and Password can be references to secrets: "env:NAME" reads the NAME env variable, "file:/path" reads the file.
+type Registry struct {
+	Registry string `json:"registry"`
+	// Type is the destination registry type, e.g. aws, google, s3, oss, gcs, azblob, filesystem or sftp
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// Scheme is how the artifactory host or docker registry is reached, http or https, https if not specified.
+	// The source artifactory files are downloaded over http if not specified.
+	Scheme string `json:"scheme"`
+	HTTP   HTTP   `json:"http"`
+}
+
+// HTTP holds the settings of the connections to a registry host, shared by every job using the host.
+// For gcs and azblob destinations, they apply to the custom endpoint, when set.
+type HTTP struct {
+	// ConnectTimeout limits the TCP connection and the TLS handshake, 30s if not specified
+	ConnectTimeout Duration `json:"connectTimeout"`
+	// ReadTimeout limits the wait for the response headers once the request is sent, 5m if not specified
+	ReadTimeout Duration `json:"readTimeout"`
+	// Timeout limits the whole request, body included, not limited if not specified
+	Timeout Duration `json:"timeout"`
+	// CABundle is a PEM file of CA certificates trusted in addition to the system ones
+	CABundle string `json:"caBundle"`
+	// ClientCert and ClientKey are the PEM files of the client certificate presented to the host
+	ClientCert string `json:"clientCert"`
+	ClientKey  string `json:"clientKey"`
+	// Proxy is the proxy URL, HTTPS_PROXY or HTTP_PROXY if not specified
+	Proxy string `json:"proxy"`
+	// NoProxy is the comma separated hosts, domains and CIDRs reached directly, NO_PROXY if not specified
+	NoProxy string `json:"noProxy"`
+	// MaxIdleConns is the number of keep-alive connections kept open to the host, 16 if not specified
+	MaxIdleConns int `json:"maxIdleConns"`
+}
+
+// Docker holds the settings of docker jobs
+type Docker struct {
+	RepoPrefix string   `json:"repoPrefix"`
+	Tag        string   `json:"tag"`
+	CopyMode   string   `json:"copyMode"`
+	TagPolicy  string   `json:"tagPolicy"`
+	Platforms  []string `json:"platforms"`
+	PageSize   int      `json:"pageSize"`
+	// Repo limits the run to this repo, unlike the Filter prefix. It is set by serve for its API and webhooks, never by the config.
+	Repo string `json:"-"`
+}
+
+// Binary holds the settings of binary jobs
+type Binary struct {
+	Force         bool   `json:"force"`
+	SyncPattern   string `json:"syncPattern"`
+	HelmCdnDomain string `json:"helmCdnDomain"`
+	OSSEndpoint   string `json:"ossEndpoint"`
+	// GCSEndpoint is the GCS JSON API root, e.g. http://localhost:4443 for a fake GCS server
+	GCSEndpoint string `json:"gcsEndpoint"`
+	// AzblobEndpoint is the blob service root, e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite
+	AzblobEndpoint string `json:"azblobEndpoint"`
+	// SFTPKnownHosts is the known hosts file sftp server keys are verified with, ~/.ssh/known_hosts if not specified
+	SFTPKnownHosts string `json:"sftpKnownHosts"`
+}
+
+// Clean is the cleanup policy, the job cleans the destination instead of replicating when enabled
+type Clean struct {
+	Enabled bool `json:"enabled"`
+	// KeepDays keeps binary files newer than this number of days
+	KeepDays int `json:"keepDays"`
+	// Prefix limits the binary cleanup to files under this prefix
+	Prefix string `json:"prefix"`
+	// Prod is the docker prod registry, its tags are never cleaned
+	Prod Registry `json:"prod"`
+}
+
+// Notify holds where the job reports to. The slack fields are a slack notifier of failures and drift.
+type Notify struct {
+	SlackWebhook string `json:"slackWebhook"`
+	// SlackToken is a bot token, posting with chat.postMessage instead of the webhook, to SlackChannel
+	SlackToken    string `json:"slackToken"`
+	SlackChannel  string `json:"slackChannel"`
+	SlackUser     string `json:"slackUser"`
+	SlackMaxItems int    `json:"slackMaxItems"`
+	BuildURL      string `json:"buildUrl"`
+	// Notifiers are more destinations of the run summaries of the job
+	Notifiers []Notifier `json:"notifiers"`
+}
+
+// Notifier is a destination of the run summaries, sent once per run
+type Notifier struct {
+	// Type is slack, teams, email or webhook
+	Type string `json:"type"`
+	// On lists the severities sent: failure, drift or cleanup, all if not specified
+	On []string `json:"on"`
+	// URL is the slack, teams or webhook URL
+	URL string `json:"url"`
+	// Token is the slack bot token, xoxb-..., posting to Channel instead of the slack webhook, with threads
+	Token string `json:"token"`
+	// Channel and User override the channel and user name of the slack webhook
+	Channel string `json:"channel"`
+	User    string `json:"user"`
+	// MaxItems caps the artifacts listed per section of the slack messages, 20 if not specified
+	MaxItems int `json:"maxItems"`
+	// Template is the text/template of the webhook JSON payload, executed with the report, the report as JSON if not specified
+	Template string `json:"template"`
+	// SMTP is the email server, host:port
+	SMTP string `json:"smtp"`
+	// SMTPUser and SMTPPassword authenticate to the email server, not authenticated if not specified
+	SMTPUser     string   `json:"smtpUser"`
+	SMTPPassword string   `json:"smtpPassword"`
+	From         string   `json:"from"`
+	To           []string `json:"to"`
+}
+
+// Creds returns the resolved source and destination credentials of the job
+func (j Job) Creds() credentials.Creds {
+	return credentials.Creds{
+		SourceUser:          j.Source.User,
+		SourcePassword:      j.Source.Password,
+		DestinationUser:     j.Destination.User,
+		DestinationPassword: j.Destination.Password,
+	}
+}
+
+// Load reads the YAML config file, applies the env variable overrides and resolves the secret references.
+// Unknown fields are reported as errors, to catch typos.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalid, path, err)
+	}
+	var c Config
+	decoder := json.NewDecoder(bytes.NewReader(j))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalid, path, err)
+	}
+	err = c.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	// with several jobs, a variable left over from the env only setup, e.g. SOURCE_PASSWORD, isn't applied to all of them
+	plain := len(c.Jobs) == 1
+	if !plain {
+		c.ignoredEnv = plainEnv()
+	}
+	for i := range c.Jobs {
+		err = c.Jobs[i].applyEnv(plain)
+		if err != nil {
+			return nil, err
+		}
+		err = c.Jobs[i].resolve()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// FromEnv returns a config with the single job described by the env variables, as before config files
+func FromEnv() (*Config, error) {
+	j := Job{Name: "env"}
+	err := j.applyEnv(true)
+	if err != nil {
+		return nil, err
+	}
+	err = j.resolve()
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{Jobs: []Job{j}}
+	err = c.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// IgnoredEnv returns the job env variables set without the JOB_<NAME>_ prefix, which only apply to a config with one job
+func (c *Config) IgnoredEnv() []string {
+	return c.ignoredEnv
+}
+
+// resolve replaces the secret reference of the tunnel key by its value
+func (c *Config) resolve() error {
+	key, err := resolveSecret(c.Tunnel.Key)
+	if err != nil {
+		return fmt.Errorf("tunnel: %w", err)
+	}
+	c.Tunnel.Key = key
+	return nil
+}
+
+// resolve replaces secret references by their values and sets defaults
+func (j *Job) resolve() error {
+	for _, secret := range []*string{
+		&j.Source.User, &j.Source.Password,
+		&j.Destination.User, &j.Destination.Password,
+		&j.Clean.Prod.User, &j.Clean.Prod.Password,
+		&j.Notify.SlackWebhook, &j.Notify.SlackToken,
+	} {
+		value, err := resolveSecret(*secret)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", j.Name, err)
+		}
+		*secret = value
+	}
+	for i := range j.Notify.Notifiers {
+		n := &j.Notify.Notifiers[i]
+		for _, secret := range []*string{&n.URL, &n.Token, &n.SMTPPassword} {
+			value, err := resolveSecret(*secret)
+			if err != nil {
+				return fmt.Errorf("job %s: %s notifier: %w", j.Name, n.Type, err)
+			}
+			*secret = value
+		}
+	}
+	if j.Type == "docker" && j.Destination.Type == "" {
+		j.Destination.Type = "azure"
+	}
+	return nil
+}
+
+func resolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, "env:") {
+		name := strings.TrimPrefix(value, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%w: secret env variable %s not set", ErrInvalid, name)
+		}
+		return v, nil
+	}
+	if strings.HasPrefix(value, "file:") {
+		b, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("%w: reading secret: %v", ErrInvalid, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration read from a Go duration string, e.g. 30s or 5m, or a number of seconds
+type Duration time.Duration
+
+// UnmarshalJSON reads the duration string or number of seconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value * float64(time.Second))
+		return nil
+	case string:
+		return d.set(value)
+	}
+	return fmt.Errorf("wrong duration %s, expected e.g. 30s or 5m", b)
+}
+
+func (d *Duration) set(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
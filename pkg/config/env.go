@@ -0,0 +1,220 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envVar sets a job field from an env variable
+type envVar struct {
+	name string
+	set  func(j *Job, value string) error
+}
+
+// envVars are applied in order, ARTIFACT_TYPE first as the clean flags depend on it
+var envVars = []envVar{
+	{"ARTIFACT_TYPE", func(j *Job, v string) error { j.Type = v; return nil }},
+	{"CHECK_REPOS", func(j *Job, v string) error { return setBool(&j.Check, v) }},
+	{"SOURCE_REGISTRY", func(j *Job, v string) error { j.Source.Registry = v; return nil }},
+	{"SOURCE_USER", func(j *Job, v string) error { j.Source.User = v; return nil }},
+	{"SOURCE_PASSWORD", func(j *Job, v string) error { j.Source.Password = v; return nil }},
+	{"DESTINATION_REGISTRY", func(j *Job, v string) error { j.Destination.Registry = v; return nil }},
+	{"DESTINATION_REGISTRY_TYPE", func(j *Job, v string) error { j.Destination.Type = v; return nil }},
+	{"DESTINATION_USER", func(j *Job, v string) error { j.Destination.User = v; return nil }},
+	{"DESTINATION_PASSWORD", func(j *Job, v string) error { j.Destination.Password = v; return nil }},
+	{"SOURCE_REGISTRY_SCHEME", func(j *Job, v string) error { j.Source.Scheme = v; return nil }},
+	{"DESTINATION_REGISTRY_SCHEME", func(j *Job, v string) error { j.Destination.Scheme = v; return nil }},
+	{"HTTP_CONNECT_TIMEOUT", func(j *Job, v string) error {
+		return j.setHTTP(func(h *HTTP) error { return h.ConnectTimeout.set(v) })
+	}},
+	{"HTTP_READ_TIMEOUT", func(j *Job, v string) error {
+		return j.setHTTP(func(h *HTTP) error { return h.ReadTimeout.set(v) })
+	}},
+	{"HTTP_TIMEOUT", func(j *Job, v string) error {
+		return j.setHTTP(func(h *HTTP) error { return h.Timeout.set(v) })
+	}},
+	{"HTTP_CA_BUNDLE", func(j *Job, v string) error {
+		return j.setHTTP(func(h *HTTP) error { h.CABundle = v; return nil })
+	}},
+	{"HTTP_CLIENT_CERT", func(j *Job, v string) error {
+		return j.setHTTP(func(h *HTTP) error { h.ClientCert = v; return nil })
+	}},
+	{"HTTP_CLIENT_KEY", func(j *Job, v string) error {
+		return j.setHTTP(func(h *HTTP) error { h.ClientKey = v; return nil })
+	}},
+	{"HTTP_MAX_IDLE_CONNS", func(j *Job, v string) error {
+		return j.setHTTP(func(h *HTTP) error { return setInt(&h.MaxIdleConns, v) })
+	}},
+	{"ARTIFACT_FILTER", func(j *Job, v string) error { j.Filter = v; return nil }},
+	{"ARTIFACT_FILTER_PROD", func(j *Job, v string) error { j.FilterProd = v; return nil }},
+	{"DOCKER_REPO_PREFIX", func(j *Job, v string) error { j.Docker.RepoPrefix = v; return nil }},
+	{"DOCKER_TAG", func(j *Job, v string) error { j.Docker.Tag = v; return nil }},
+	{"DOCKER_COPY_MODE", func(j *Job, v string) error { j.Docker.CopyMode = v; return nil }},
+	{"DOCKER_TAG_POLICY", func(j *Job, v string) error { j.Docker.TagPolicy = v; return nil }},
+	{"DOCKER_PLATFORMS", func(j *Job, v string) error { j.Docker.Platforms = strings.Split(v, ","); return nil }},
+	{"DOCKER_PAGE_SIZE", func(j *Job, v string) error { return setInt(&j.Docker.PageSize, v) }},
+	{"DOCKER_CLEAN", func(j *Job, v string) error {
+		if j.Type != "docker" {
+			return nil
+		}
+		return setBool(&j.Clean.Enabled, v)
+	}},
+	{"SOURCE_PROD_REGISTRY", func(j *Job, v string) error { j.Clean.Prod.Registry = v; return nil }},
+	{"SOURCE_PROD_REGISTRY_USER", func(j *Job, v string) error { j.Clean.Prod.User = v; return nil }},
+	{"SOURCE_PROD_REGISTRY_PASSWORD", func(j *Job, v string) error { j.Clean.Prod.Password = v; return nil }},
+	{"FORCE", func(j *Job, v string) error { return setBool(&j.Binary.Force, v) }},
+	{"SYNC_PATTERN", func(j *Job, v string) error { j.Binary.SyncPattern = v; return nil }},
+	{"HELM_CDN_DOMAIN", func(j *Job, v string) error { j.Binary.HelmCdnDomain = v; return nil }},
+	{"OSS_ENDPOINT", func(j *Job, v string) error { j.Binary.OSSEndpoint = v; return nil }},
+	{"GCS_ENDPOINT", func(j *Job, v string) error { j.Binary.GCSEndpoint = v; return nil }},
+	{"AZBLOB_ENDPOINT", func(j *Job, v string) error { j.Binary.AzblobEndpoint = v; return nil }},
+	{"SFTP_KNOWN_HOSTS", func(j *Job, v string) error { j.Binary.SFTPKnownHosts = v; return nil }},
+	{"BINARY_CLEAN", func(j *Job, v string) error {
+		if j.Type != "binary" {
+			return nil
+		}
+		return setBool(&j.Clean.Enabled, v)
+	}},
+	{"BINARY_CLEAN_KEEP_DAYS", func(j *Job, v string) error { return setInt(&j.Clean.KeepDays, v) }},
+	{"BINARY_CLEAN_PREFIX", func(j *Job, v string) error { j.Clean.Prefix = v; return nil }},
+	{"CONCURRENCY", func(j *Job, v string) error { return setInt(&j.Concurrency, v) }},
+	{"CONCURRENCY_PER_HOST", func(j *Job, v string) error { return setInt(&j.ConcurrencyPerHost, v) }},
+	{"SCHEDULE", func(j *Job, v string) error { j.Schedule = v; return nil }},
+	{"SCHEDULE_JITTER", func(j *Job, v string) error { return j.Jitter.set(v) }},
+	{"SLACK_WEBHOOK", func(j *Job, v string) error { j.Notify.SlackWebhook = v; return nil }},
+	{"SLACK_TOKEN", func(j *Job, v string) error { j.Notify.SlackToken = v; return nil }},
+	{"SLACK_CHANNEL", func(j *Job, v string) error { j.Notify.SlackChannel = v; return nil }},
+	{"SLACK_USER", func(j *Job, v string) error { j.Notify.SlackUser = v; return nil }},
+	{"SLACK_MAX_ITEMS", func(j *Job, v string) error { return setInt(&j.Notify.SlackMaxItems, v) }},
+	{"BUILD_URL", func(j *Job, v string) error { j.Notify.BuildURL = v; return nil }},
+	{"TEAMS_WEBHOOK", func(j *Job, v string) error { j.Notify.notifier("teams").URL = v; return nil }},
+	{"NOTIFY_WEBHOOK", func(j *Job, v string) error { j.Notify.notifier("webhook").URL = v; return nil }},
+	{"NOTIFY_WEBHOOK_TEMPLATE", func(j *Job, v string) error { j.Notify.notifier("webhook").Template = v; return nil }},
+	{"SMTP_SERVER", func(j *Job, v string) error { j.Notify.notifier("email").SMTP = v; return nil }},
+	{"SMTP_USER", func(j *Job, v string) error { j.Notify.notifier("email").SMTPUser = v; return nil }},
+	{"SMTP_PASSWORD", func(j *Job, v string) error { j.Notify.notifier("email").SMTPPassword = v; return nil }},
+	{"EMAIL_FROM", func(j *Job, v string) error { j.Notify.notifier("email").From = v; return nil }},
+	{"EMAIL_TO", func(j *Job, v string) error { j.Notify.notifier("email").To = strings.Split(v, ","); return nil }},
+	// NOTIFY_ON comes after the notifiers it applies to
+	{"NOTIFY_ON", func(j *Job, v string) error {
+		for i := range j.Notify.Notifiers {
+			j.Notify.Notifiers[i].On = strings.Split(v, ",")
+		}
+		return nil
+	}},
+}
+
+// configEnvVars set the fields of the config that aren't per job
+var configEnvVars = []struct {
+	name string
+	set  func(c *Config, value string) error
+}{
+	{"JUMP_HOST_NAME", func(c *Config, v string) error { c.Tunnel.Host = v; return nil }},
+	{"JUMP_HOST_USER", func(c *Config, v string) error { c.Tunnel.User = v; return nil }},
+	{"JUMP_HOST_KEY", func(c *Config, v string) error { c.Tunnel.Key = v; return nil }},
+	{"JUMP_HOST_KNOWN_HOSTS", func(c *Config, v string) error { c.Tunnel.KnownHosts = v; return nil }},
+	{"JUMP_HOST_DESTINATION", func(c *Config, v string) error { c.Tunnel.Destination = v; return nil }},
+	{"JUMP_HOST_LOCAL_PORT", func(c *Config, v string) error { return setInt(&c.Tunnel.LocalPort, v) }},
+}
+
+// applyEnv overrides the config fields with the env variables set to a non empty value
+func (c *Config) applyEnv() error {
+	for _, e := range configEnvVars {
+		value := os.Getenv(e.name)
+		if value == "" {
+			continue
+		}
+		err := e.set(c, value)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalid, e.name, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides the job fields with the env variables set to a non empty value.
+// JOB_<NAME>_<VARIABLE>, e.g. JOB_DOCKER_PROD_DOCKER_TAG for the job docker-prod, only applies to the named job
+// and wins over <VARIABLE>, which only applies if plain is true: for the job of FromEnv or the only job of a config file.
+func (j *Job) applyEnv(plain bool) error {
+	prefix := "JOB_" + envName(j.Name) + "_"
+	for _, e := range envVars {
+		value := os.Getenv(prefix + e.name)
+		if value == "" && plain {
+			value = os.Getenv(e.name)
+		}
+		if value == "" {
+			continue
+		}
+		err := e.set(j, value)
+		if err != nil {
+			return fmt.Errorf("%w: job %s: %s: %v", ErrInvalid, j.Name, e.name, err)
+		}
+	}
+	return nil
+}
+
+// plainEnv returns the job env variables set without the JOB_<NAME>_ prefix
+func plainEnv() []string {
+	var names []string
+	for _, e := range envVars {
+		if os.Getenv(e.name) != "" {
+			names = append(names, e.name)
+		}
+	}
+	return names
+}
+
+// setHTTP applies set to the HTTP settings of every registry of the job
+func (j *Job) setHTTP(set func(h *HTTP) error) error {
+	for _, h := range []*HTTP{&j.Source.HTTP, &j.Destination.HTTP, &j.Clean.Prod.HTTP} {
+		err := set(h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifier returns the first notifier of the type, adding it if there is none
+func (n *Notify) notifier(typ string) *Notifier {
+	for i := range n.Notifiers {
+		if n.Notifiers[i].Type == typ {
+			return &n.Notifiers[i]
+		}
+	}
+	n.Notifiers = append(n.Notifiers, Notifier{Type: typ})
+	return &n.Notifiers[len(n.Notifiers)-1]
+}
+
+// envName converts a job name to the env variable form, e.g. docker-prod to DOCKER_PROD
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func setBool(field *bool, value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*field = b
+	return nil
+}
+
+func setInt(field *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*field = n
+	return nil
+}
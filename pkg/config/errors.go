@@ -0,0 +1,6 @@
+package config
+
+import "errors"
+
+// ErrInvalid is wrapped by errors caused by a missing or wrong setting, as opposed to a failed network or storage call
+var ErrInvalid = errors.New("invalid configuration")
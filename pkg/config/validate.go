@@ -0,0 +1,263 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/cron"
+	"github.com/loqutus/artifactory-replication/pkg/tmpl"
+)
+
+var dockerDestinationTypes = []string{"azure", "aws", "alicloud", "google"}
+
+var binaryDestinationTypes = []string{"s3", "artifactory", "oss", "gcs", "azblob", "filesystem", "sftp"}
+
+var notifySeverities = []string{"failure", "drift", "cleanup"}
+
+// Validate checks every job without any network call, returning all the problems found at once
+func (c *Config) Validate() error {
+	var problems []string
+	if len(c.Jobs) == 0 {
+		problems = append(problems, "no jobs")
+	}
+	for _, p := range c.Tunnel.problems() {
+		problems = append(problems, "tunnel: "+p)
+	}
+	names := make(map[string]bool)
+	for _, j := range c.Jobs {
+		if names[j.Name] {
+			problems = append(problems, "job "+j.Name+": duplicate job name")
+		}
+		names[j.Name] = true
+		for _, p := range j.problems() {
+			problems = append(problems, "job "+j.Name+": "+p)
+		}
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("%w:\n%s", ErrInvalid, strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Validate checks the job without any network call
+func (j Job) Validate() error {
+	problems := j.problems()
+	if len(problems) != 0 {
+		return fmt.Errorf("%w: job %s: %s", ErrInvalid, j.Name, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func (j Job) problems() []string {
+	var problems []string
+	if j.Name == "" {
+		problems = append(problems, "empty name")
+	}
+	if j.Source.Registry == "" {
+		problems = append(problems, "empty source registry (SOURCE_REGISTRY)")
+	}
+	if j.Destination.Registry == "" {
+		problems = append(problems, "empty destination registry (DESTINATION_REGISTRY)")
+	}
+	if j.Concurrency < 0 || j.ConcurrencyPerHost < 0 {
+		problems = append(problems, "negative concurrency")
+	}
+	if j.Schedule != "" {
+		_, err := cron.Parse(j.Schedule)
+		if err != nil {
+			problems = append(problems, "wrong schedule (SCHEDULE): "+err.Error())
+		}
+	}
+	if j.Jitter < 0 {
+		problems = append(problems, "negative jitter (SCHEDULE_JITTER)")
+	}
+	for _, r := range []struct {
+		name     string
+		registry Registry
+	}{{"source", j.Source}, {"destination", j.Destination}, {"prod", j.Clean.Prod}} {
+		for _, p := range r.registry.problems() {
+			problems = append(problems, r.name+" registry: "+p)
+		}
+	}
+	switch j.Type {
+	case "docker":
+		if !contains(dockerDestinationTypes, j.Destination.Type) {
+			problems = append(problems, fmt.Sprintf("unknown docker destination type (DESTINATION_REGISTRY_TYPE) %q, expected one of %s", j.Destination.Type, strings.Join(dockerDestinationTypes, ", ")))
+		}
+		problems = append(problems, j.dockerProblems()...)
+	case "binary":
+		if !contains(binaryDestinationTypes, j.Destination.Type) {
+			problems = append(problems, fmt.Sprintf("unknown binary destination type (DESTINATION_REGISTRY_TYPE) %q, expected one of %s", j.Destination.Type, strings.Join(binaryDestinationTypes, ", ")))
+		}
+		problems = append(problems, j.binaryProblems()...)
+	default:
+		problems = append(problems, fmt.Sprintf("unknown artifact type (ARTIFACT_TYPE) %q, expected docker or binary", j.Type))
+	}
+	if j.Notify.SlackToken != "" && j.Notify.SlackChannel == "" {
+		problems = append(problems, "slack token (SLACK_TOKEN) without channel (SLACK_CHANNEL)")
+	}
+	if j.Notify.SlackMaxItems < 0 {
+		problems = append(problems, "negative slack max items (SLACK_MAX_ITEMS)")
+	}
+	for _, n := range j.Notify.Notifiers {
+		for _, p := range n.problems() {
+			problems = append(problems, n.Type+" notifier: "+p)
+		}
+	}
+	return problems
+}
+
+func (j Job) dockerProblems() []string {
+	var problems []string
+	if j.Docker.CopyMode != "" && j.Docker.CopyMode != "daemon" && j.Docker.CopyMode != "registry" {
+		problems = append(problems, fmt.Sprintf("unknown copy mode (DOCKER_COPY_MODE) %q, expected daemon or registry", j.Docker.CopyMode))
+	}
+	if j.Docker.TagPolicy != "" && j.Docker.TagPolicy != "name" && j.Docker.TagPolicy != "digest" && j.Docker.TagPolicy != "immutable" {
+		problems = append(problems, fmt.Sprintf("unknown tag policy (DOCKER_TAG_POLICY) %q, expected name, digest or immutable", j.Docker.TagPolicy))
+	}
+	for _, p := range j.Docker.Platforms {
+		parts := strings.Split(p, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("wrong platform (DOCKER_PLATFORMS) %q, expected os/arch or os/arch/variant", p))
+		}
+	}
+	if j.Docker.PageSize < 0 {
+		problems = append(problems, "negative page size (DOCKER_PAGE_SIZE)")
+	}
+	if j.Clean.Enabled && !j.Check && j.Clean.Prod.Registry == "" {
+		problems = append(problems, "clean enabled without prod registry (SOURCE_PROD_REGISTRY)")
+	}
+	return problems
+}
+
+func (j Job) binaryProblems() []string {
+	var problems []string
+	if j.Binary.SyncPattern != "" {
+		_, err := regexp.Compile(j.Binary.SyncPattern)
+		if err != nil {
+			problems = append(problems, "wrong sync pattern (SYNC_PATTERN): "+err.Error())
+		}
+	}
+	if j.Destination.Type == "azblob" && (j.Destination.User == "" || j.Destination.Password == "") {
+		problems = append(problems, "azblob destination without storage account (DESTINATION_USER) or SAS token or shared key (DESTINATION_PASSWORD)")
+	}
+	if j.Destination.Type == "sftp" && (j.Destination.User == "" || j.Destination.Password == "") {
+		problems = append(problems, "sftp destination without user (DESTINATION_USER) or password or private key (DESTINATION_PASSWORD)")
+	}
+	if j.Clean.Enabled && !j.Check {
+		if j.Clean.KeepDays <= 0 {
+			problems = append(problems, "clean enabled without keep days (BINARY_CLEAN_KEEP_DAYS)")
+		}
+		if j.Clean.Prefix == "" {
+			problems = append(problems, "clean enabled without prefix (BINARY_CLEAN_PREFIX)")
+		}
+		if j.Binary.HelmCdnDomain == "" {
+			problems = append(problems, "clean enabled without helm cdn domain (HELM_CDN_DOMAIN)")
+		}
+	}
+	return problems
+}
+
+func (r Registry) problems() []string {
+	var problems []string
+	if r.Scheme != "" && r.Scheme != "http" && r.Scheme != "https" {
+		problems = append(problems, fmt.Sprintf("unknown scheme (SOURCE_REGISTRY_SCHEME, DESTINATION_REGISTRY_SCHEME) %q, expected http or https", r.Scheme))
+	}
+	h := r.HTTP
+	if h.ConnectTimeout < 0 || h.ReadTimeout < 0 || h.Timeout < 0 {
+		problems = append(problems, "negative http timeout")
+	}
+	if (h.ClientCert == "") != (h.ClientKey == "") {
+		problems = append(problems, "http client certificate (HTTP_CLIENT_CERT) without key (HTTP_CLIENT_KEY) or the reverse")
+	}
+	if h.Proxy != "" {
+		u, err := url.Parse(h.Proxy)
+		if err != nil || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("wrong http proxy %q, expected a URL like http://proxy.example.com:3128", h.Proxy))
+		}
+	}
+	if h.MaxIdleConns < 0 {
+		problems = append(problems, "negative http max idle connections (HTTP_MAX_IDLE_CONNS)")
+	}
+	return problems
+}
+
+func (n Notifier) problems() []string {
+	var problems []string
+	for _, severity := range n.On {
+		if !contains(notifySeverities, severity) {
+			problems = append(problems, fmt.Sprintf("unknown severity (NOTIFY_ON) %q, expected one of %s", severity, strings.Join(notifySeverities, ", ")))
+		}
+	}
+	switch n.Type {
+	case "slack", "teams", "webhook":
+		if n.Type == "slack" && n.Token != "" {
+			if n.Channel == "" {
+				problems = append(problems, "token without channel")
+			}
+		} else if u, err := url.Parse(n.URL); err != nil || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("wrong url %q", n.URL))
+		}
+		if n.MaxItems < 0 {
+			problems = append(problems, "negative max items")
+		}
+		if n.Template != "" {
+			_, err := tmpl.Parse("webhook", n.Template)
+			if err != nil {
+				problems = append(problems, "wrong template (NOTIFY_WEBHOOK_TEMPLATE): "+err.Error())
+			}
+		}
+	case "email":
+		_, _, err := net.SplitHostPort(n.SMTP)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("wrong smtp server (SMTP_SERVER) %q, expected host:port", n.SMTP))
+		}
+		if n.From == "" || len(n.To) == 0 {
+			problems = append(problems, "email without sender (EMAIL_FROM) or recipients (EMAIL_TO)")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown notifier type %q, expected slack, teams, email or webhook", n.Type))
+	}
+	return problems
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Tunnel) problems() []string {
+	var problems []string
+	if t.Host == "" {
+		if t.Destination != "" || t.LocalPort != 0 {
+			problems = append(problems, "destination or local port without jump host (JUMP_HOST_NAME)")
+		}
+		return problems
+	}
+	if t.User == "" {
+		problems = append(problems, "empty jump host user (JUMP_HOST_USER)")
+	}
+	if t.Key == "" {
+		problems = append(problems, "empty jump host key (JUMP_HOST_KEY)")
+	}
+	if t.Destination != "" {
+		_, _, err := net.SplitHostPort(t.Destination)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("wrong jump host destination (JUMP_HOST_DESTINATION) %q, expected host:port", t.Destination))
+		}
+	}
+	if t.LocalPort < 0 || t.LocalPort > 65535 {
+		problems = append(problems, fmt.Sprintf("wrong jump host local port (JUMP_HOST_LOCAL_PORT) %d", t.LocalPort))
+	}
+	if t.LocalPort != 0 && t.Destination == "" {
+		problems = append(problems, "jump host local port without destination (JUMP_HOST_DESTINATION)")
+	}
+	return problems
+}
@@ -0,0 +1,189 @@
+package cron
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a scheduled job runs next
+type Schedule interface {
+	// Next returns the first run time after t, or the zero time if there is none
+	Next(t time.Time) time.Time
+}
+
+// Parse returns the schedule of a cron expression, a descriptor or an interval:
+//   - "*/15 6-22 * * MON-FRI": minute, hour, day of month, month and day of week, with lists, ranges, steps and names
+//   - "@hourly", "@daily" or "@midnight", "@weekly", "@monthly", "@yearly" or "@annually"
+//   - "@every 30m" or "30m": every interval, from the previous run
+//
+// Cron times are in the local time zone, set with TZ.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every"))); err == nil {
+		if d < time.Second {
+			return nil, errors.New("interval " + spec + " shorter than a second")
+		}
+		return every(d), nil
+	}
+	if descriptor, ok := descriptors[spec]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("cron expression " + strconv.Quote(spec) + " has " + strconv.Itoa(len(fields)) + " fields, expected 5: minute hour day-of-month month day-of-week")
+	}
+	var c cron
+	var err error
+	for i, f := range []struct {
+		field *uint64
+		r     bounds
+	}{{&c.minute, minutes}, {&c.hour, hours}, {&c.dom, days}, {&c.month, months}, {&c.dow, weekdays}} {
+		*f.field, err = parseField(fields[i], f.r)
+		if err != nil {
+			return nil, errors.New("cron expression " + strconv.Quote(spec) + ": " + err.Error())
+		}
+	}
+	// 7 is sunday too
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*" || fields[2] == "?"
+	c.dowAny = fields[4] == "*" || fields[4] == "?"
+	return c, nil
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// cron has a bit set for every allowed value of each field
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny tell a field is *: otherwise a day matches if either the day of month or of week does
+	domAny, dowAny bool
+}
+
+type bounds struct {
+	min, max int
+	names    []string
+}
+
+var (
+	minutes  = bounds{0, 59, nil}
+	hours    = bounds{0, 23, nil}
+	days     = bounds{1, 31, nil}
+	months   = bounds{1, 12, []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	weekdays = bounds{0, 7, []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}}
+)
+
+// parseField parses a comma separated list of *, values and ranges, each with an optional /step
+func parseField(field string, r bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.New("wrong step in " + strconv.Quote(part))
+			}
+			rangePart = part[:i]
+		}
+		low, high := r.min, r.max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			ends := strings.SplitN(rangePart, "-", 2)
+			var err error
+			low, err = r.value(ends[0])
+			if err != nil {
+				return 0, err
+			}
+			high, err = r.value(ends[1])
+			if err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, errors.New("wrong range " + strconv.Quote(rangePart))
+			}
+		default:
+			var err error
+			low, err = r.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			// a/step runs from a to the maximum
+			if step == 1 {
+				high = low
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or a name of the field
+func (r bounds) value(s string) (int, error) {
+	for i, name := range r.names {
+		if strings.EqualFold(s, name) {
+			// months start at 1, weekdays at 0
+			return i + r.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < r.min || v > r.max {
+		return 0, errors.New("wrong value " + strconv.Quote(s) + ", expected " + strconv.Itoa(r.min) + "-" + strconv.Itoa(r.max))
+	}
+	return v, nil
+}
+
+func (c cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// a schedule without any time in 5 years, e.g. February 30, never runs
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,271 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+)
+
+// tokenRefreshMargin is how long before expiry a cached token is refreshed
+const tokenRefreshMargin = 10 * time.Second
+
+// defaultTokenLifetime is used when the token server doesn't return expires_in, as in the token spec
+const defaultTokenLifetime = 60 * time.Second
+
+// challenge is a parsed WWW-Authenticate header
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+type bearerToken struct {
+	token   string
+	expires time.Time
+}
+
+// authTransport authenticates registry requests. It answers the WWW-Authenticate Bearer challenge
+// with the docker token flow, caching tokens per host and scope, and falls back to basic auth for the registry host.
+// Other hosts, e.g. the storage a blob GET is redirected to, get no credentials unless they sent a challenge.
+type authTransport struct {
+	// host is the host of the registry, the only one sent basic auth
+	host string
+	user string
+	pass string
+	base http.RoundTripper
+
+	mu         sync.Mutex
+	challenges map[string]challenge
+	tokens     map[string]bearerToken
+}
+
+var authTransports = struct {
+	sync.Mutex
+	transports map[string]*authTransport
+}{transports: make(map[string]*authTransport)}
+
+// newAuthClient returns an HTTP client of the registry sharing the token cache with every other client
+// using the same registry and credentials
+func newAuthClient(registry string, user string, pass string) *http.Client {
+	authTransports.Lock()
+	defer authTransports.Unlock()
+	host := registry
+	if u, err := url.Parse(httpclient.URL(registry)); err == nil {
+		host = u.Host
+	}
+	key := host + "\x00" + user + "\x00" + pass
+	t, ok := authTransports.transports[key]
+	if !ok {
+		t = &authTransport{
+			host:       host,
+			user:       user,
+			pass:       pass,
+			base:       httpclient.Transport,
+			challenges: make(map[string]challenge),
+			tokens:     make(map[string]bearerToken),
+		}
+		authTransports.transports[key] = t
+	}
+	return &http.Client{Transport: t}
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	scope := requestScope(req)
+	authReq := req.Clone(req.Context())
+	t.mu.Lock()
+	c, bearer := t.challenges[host]
+	t.mu.Unlock()
+	if bearer {
+		token, err := t.token(host, c, scope)
+		if err != nil {
+			return nil, err
+		}
+		authReq.Header.Set("Authorization", "Bearer "+token)
+	} else if strings.EqualFold(host, t.host) && (t.user != "" || t.pass != "") {
+		authReq.SetBasicAuth(t.user, t.pass)
+	}
+	resp, err := t.base.RoundTrip(authReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	c, ok := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if !ok || c.scheme != "bearer" || c.params["realm"] == "" {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// the body was consumed and can't be replayed, the caller gets the 401
+		t.rememberChallenge(host, c)
+		return resp, nil
+	}
+	if c.params["scope"] != "" {
+		scope = c.params["scope"]
+	}
+	t.rememberChallenge(host, c)
+	t.mu.Lock()
+	delete(t.tokens, host+" "+scope)
+	t.mu.Unlock()
+	token, err := t.token(host, c, scope)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retryReq.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retryReq.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(retryReq)
+}
+
+func (t *authTransport) rememberChallenge(host string, c challenge) {
+	t.mu.Lock()
+	t.challenges[host] = c
+	t.mu.Unlock()
+}
+
+// token returns a cached token for the host and scope, fetching a new one from the challenge realm when missing or expiring
+func (t *authTransport) token(host string, c challenge, scope string) (string, error) {
+	key := host + " " + scope
+	t.mu.Lock()
+	cached, ok := t.tokens[key]
+	t.mu.Unlock()
+	if ok && time.Now().Add(tokenRefreshMargin).Before(cached.expires) {
+		return cached.token, nil
+	}
+	realm, err := url.Parse(c.params["realm"])
+	if err != nil {
+		return "", err
+	}
+	query := realm.Query()
+	if c.params["service"] != "" {
+		query.Set("service", c.params["service"])
+	}
+	for _, s := range strings.Fields(scope) {
+		query.Add("scope", s)
+	}
+	realm.RawQuery = query.Encode()
+	logger.Debug("Getting registry token", "host", host, "scope", scope)
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if t.user != "" || t.pass != "" {
+		req.SetBasicAuth(t.user, t.pass)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", retry.NewHTTPError(resp.StatusCode, "GET "+realm.Host+realm.Path+": "+resp.Status+": "+strings.TrimSpace(string(body)))
+	}
+	var res struct {
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		IssuedAt    time.Time `json:"issued_at"`
+	}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return "", err
+	}
+	token := res.Token
+	if token == "" {
+		token = res.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("empty token returned by " + realm.Host + realm.Path)
+	}
+	issued := res.IssuedAt
+	if issued.IsZero() || issued.After(time.Now()) {
+		issued = time.Now()
+	}
+	lifetime := time.Duration(res.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	t.mu.Lock()
+	t.tokens[key] = bearerToken{token: token, expires: issued.Add(lifetime)}
+	t.mu.Unlock()
+	return token, nil
+}
+
+// requestScope guesses the token scope a registry request needs from its path and method
+func requestScope(req *http.Request) string {
+	path := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/v2/"), "/acr/v1/")
+	if path == "_catalog" {
+		return "registry:catalog:*"
+	}
+	for _, marker := range []string{"/manifests/", "/blobs/", "/tags/", "/_manifests", "/_tags/"} {
+		if i := strings.Index(path, marker); i != -1 {
+			return "repository:" + path[:i] + ":" + scopeActions(req.Method)
+		}
+	}
+	return ""
+}
+
+func scopeActions(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "pull"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return "pull,push"
+}
+
+// parseChallenge parses a WWW-Authenticate header like: Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) (challenge, bool) {
+	header = strings.TrimSpace(header)
+	i := strings.Index(header, " ")
+	if i == -1 {
+		return challenge{scheme: strings.ToLower(header), params: map[string]string{}}, header != ""
+	}
+	c := challenge{scheme: strings.ToLower(header[:i]), params: make(map[string]string)}
+	rest := header[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end == -1 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end:]
+			}
+		}
+		c.params[key] = value
+	}
+	return c, true
+}
@@ -0,0 +1,53 @@
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAuthRedirect checks that a blob GET redirected to a storage host, e.g. a presigned S3 URL,
+// doesn't send the registry credentials there
+func TestAuthRedirect(t *testing.T) {
+	var storageAuth string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageAuth = r.Header.Get("Authorization")
+		w.Write([]byte("blob"))
+	}))
+	defer storage.Close()
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, storage.URL+"/blob?X-Amz-Signature=abc", http.StatusTemporaryRedirect)
+	}))
+	defer registry.Close()
+	client := newAuthClient(strings.TrimPrefix(registry.URL, "http://"), "user", "pass")
+	resp, err := client.Get(registry.URL + "/v2/app/blobs/sha256:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET returned %s", resp.Status)
+	}
+	if storageAuth != "" {
+		t.Errorf("redirect target sent Authorization %q", storageAuth)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	c, ok := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:app:pull"`)
+	if !ok || c.scheme != "bearer" {
+		t.Fatalf("parsed %+v, %v", c, ok)
+	}
+	expected := map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:app:pull"}
+	for key, value := range expected {
+		if c.params[key] != value {
+			t.Errorf("%s is %q, expected %q", key, c.params[key], value)
+		}
+	}
+}
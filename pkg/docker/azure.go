@@ -3,44 +3,28 @@ package docker
 import (
 	"errors"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"regexp"
 	"strings"
-	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
 func GetAzureDockerTagManifestDigest(registry string, image string, tag string, user string, pass string) (string, error) {
-	log.Println("Getting tag manifest digest:", image+":"+tag)
-	url := "https://" + registry + "/acr/v1/" + image + "/_manifests"
-	client := &http.Client{}
+	logger.Debug("Getting tag manifest digest", "destination", registry, "repo", image, "tag", tag)
+	url := httpclient.URL(registry) + "/acr/v1/" + image + "/_manifests"
+	client := newAuthClient(registry, user, pass)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", err
 	}
-	req.SetBasicAuth(user, pass)
-	var resp *http.Response
-	var failed bool
-	backOffTime := backOffStart
-	for i := 1; i <= backOffSteps; i++ {
-		resp, err = client.Do(req)
-
-		if err != nil {
-			failed = true
-			log.Print("error HTTP GET", url, "retry", string(i))
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			defer resp.Body.Close()
-			failed = false
-			break
-		}
-	}
-	if failed == true {
+	resp, err := retry.HTTP(client, req)
+	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
@@ -1,76 +1,69 @@
 package docker
 
 import (
-	"log"
-
-	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/result"
 )
 
-var CheckFailed bool
-var CheckFailedList []string
-var MissingRepos, MissingRepoTags []string
-var RemovedTags, SkippedTags uint64
-
-func CheckRepos(sourceRegistry string, destinationRegistry string, destinationRegistryType string, creds credentials.Creds) error {
-	var reposLimit string
-	if destinationRegistryType == "aws" {
-		reposLimit = "1000"
-	} else {
-		reposLimit = "1000000"
-	}
-	log.Println("Getting source repos from: " + sourceRegistry)
-	sourceRepos, err := GetRepos(sourceRegistry, creds.SourceUser, creds.SourcePassword, "1000000")
+// CheckRepos records every source repo and tag missing at destination as a failed item of the result
+func CheckRepos(job config.Job) (*result.Result, error) {
+	sourceRegistry := job.Source.Registry
+	destinationRegistry := job.Destination.Registry
+	creds := job.Creds()
+	res := result.New()
+	defer res.Finish()
+	l := logger.Job(job)
+	l.Info("Getting source repos")
+	sourceRepos, err := GetRepos(sourceRegistry, creds.SourceUser, creds.SourcePassword, job.Docker.PageSize)
 	if err != nil {
-		return err
+		return res, err
 	}
-	log.Println("Getting destination repos from: " + destinationRegistry)
-	destinationRepos, err := GetRepos(destinationRegistry, creds.DestinationUser, creds.DestinationPassword, reposLimit)
+	l.Info("Getting destination repos")
+	destinationRepos, err := GetRepos(destinationRegistry, creds.DestinationUser, creds.DestinationPassword, job.Docker.PageSize)
 	if err != nil {
-		return err
+		return res, err
 	}
 	for _, sourceRepo := range sourceRepos {
 		var destinationRepoFound bool
 		for _, destinationRepo := range destinationRepos {
 			if sourceRepo == destinationRepo {
-				log.Println("Repo " + sourceRepo + " found")
+				l.Debug("Repo found", "repo", sourceRepo)
 				destinationRepoFound = true
 				break
 			}
 		}
 		if !destinationRepoFound {
-			log.Println("Repo " + sourceRepo + " NOT found")
-			CheckFailed = true
-			MissingRepos = append(MissingRepos, sourceRepo)
+			l.Warn("Repo not found", "repo", sourceRepo)
+			res.AddMissing(sourceRepo, "repo not found")
+			continue
 		}
-		if !CheckFailed {
-			sourceRepoTags, err := listTags(sourceRegistry, sourceRepo, creds.SourceUser, creds.SourcePassword)
-			if err != nil {
-				log.Println("Failed to get tags for repo: " + sourceRepo)
-				MissingRepos = append(MissingRepos, sourceRepo)
-				CheckFailed = true
-			}
-			destinationRepoTags, err := listTags(destinationRegistry, sourceRepo, creds.DestinationUser, creds.DestinationPassword)
-			if err != nil {
-				log.Println("Failed to get tags for repo: " + sourceRepo)
-				MissingRepos = append(MissingRepos, sourceRepo)
-				CheckFailed = true
-			}
-			for _, sourceRepoTag := range sourceRepoTags {
-				tagFound := false
-				for _, destinationRepoTag := range destinationRepoTags {
-					if sourceRepoTag == destinationRepoTag {
-						log.Println("Repo tag: " + sourceRepo + ":" + sourceRepoTag + " found")
-						tagFound = true
-						break
-					}
-				}
-				if !tagFound {
-					log.Println("Tag not found: " + sourceRepoTag)
-					MissingRepoTags = append(MissingRepoTags, sourceRepo+":"+sourceRepoTag)
-					CheckFailed = true
+		sourceRepoTags, err := listTags(sourceRegistry, sourceRepo, creds.SourceUser, creds.SourcePassword, job.Docker.PageSize)
+		if err != nil {
+			l.Error("Failed to get source tags", "repo", sourceRepo, "err", err)
+			res.AddFailed(sourceRepo, "check", err)
+			continue
+		}
+		destinationRepoTags, err := listTags(destinationRegistry, sourceRepo, creds.DestinationUser, creds.DestinationPassword, job.Docker.PageSize)
+		if err != nil {
+			l.Error("Failed to get destination tags", "repo", sourceRepo, "err", err)
+			res.AddFailed(sourceRepo, "check", err)
+			continue
+		}
+		for _, sourceRepoTag := range sourceRepoTags {
+			tagFound := false
+			for _, destinationRepoTag := range destinationRepoTags {
+				if sourceRepoTag == destinationRepoTag {
+					l.Debug("Repo tag found", "repo", sourceRepo, "tag", sourceRepoTag)
+					tagFound = true
+					break
 				}
 			}
+			if !tagFound {
+				l.Warn("Repo tag not found", "repo", sourceRepo, "tag", sourceRepoTag)
+				res.AddMissing(sourceRepo+":"+sourceRepoTag, "tag not found")
+			}
 		}
 	}
-	return nil
+	return res, nil
 }
@@ -1,47 +1,40 @@
 package docker
 
 import (
-	"log"
-	"os"
+	"fmt"
 	"strings"
 	"time"
 
-	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/plan"
 )
 
-func Clean(reposLimit string, sourceFilteredRepos []string, destinationFilteredRepos []string, artifactFilter string, destinationRegistry string, creds credentials.Creds, destinationRegistryType string) {
-	log.Println("Cleaning repo:", destinationRegistry)
-	sourceProdRegistry := os.Getenv("SOURCE_PROD_REGISTRY")
+// planClean plans the removal of tags older than yesterday from the destination repos, keeping the tags present in the job prod registry
+func planClean(destinationFilteredRepos []string, job config.Job, p *plan.Plan) error {
+	destinationRegistry := job.Destination.Registry
+	creds := job.Creds()
+	l := logger.Job(job)
+	l.Info("Cleaning destination registry")
+	sourceProdRegistry := job.Clean.Prod.Registry
 	if sourceProdRegistry == "" {
-		panic("empty SOURCE_PROD_REGISTRY")
+		return fmt.Errorf("%w: empty SOURCE_PROD_REGISTRY", config.ErrInvalid)
 	}
-	log.Println("Getting repos from prod source registry: " + sourceProdRegistry)
-	prodSourceRegistryUser := os.Getenv("SOURCE_PROD_REGISTRY_USER")
-	prodSourceRegistryPassword := os.Getenv("SOURCE_PROD_REGISTRY_PASSWORD")
-	log.Println("I'm going to remove yesterday and older tags")
-	sourceProdRepos, err := GetRepos(sourceProdRegistry, prodSourceRegistryUser, prodSourceRegistryPassword, reposLimit)
+	l.Info("Getting repos from prod source registry", "prod", sourceProdRegistry)
+	prodSourceRegistryUser := job.Clean.Prod.User
+	prodSourceRegistryPassword := job.Clean.Prod.Password
+	sourceProdRepos, err := GetRepos(sourceProdRegistry, prodSourceRegistryUser, prodSourceRegistryPassword, job.Docker.PageSize)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("listing repos of %s: %w", sourceProdRegistry, err)
 	}
-	var prodSourceFilteredRepos []string
-	if artifactFilter != "" {
-		for _, sourceRepo := range sourceProdRepos {
-			if strings.HasPrefix(sourceRepo, artifactFilter) {
-				prodSourceFilteredRepos = append(prodSourceFilteredRepos, sourceRepo)
-			}
-		}
-	} else {
-		sourceFilteredRepos = sourceProdRepos
-	}
-	log.Println("Found prod source repos: ", len(sourceProdRepos))
+	l.Info("Found prod source repos", "count", len(sourceProdRepos))
 	dt := time.Now()
 	dtYesterday := time.Now().AddDate(0, 0, -1)
 	dateNow := dt.Format("2006-01-02")
 	dateYesterday := dtYesterday.Format("2006-01-02")
-	log.Println("Date Now:", dateNow)
-	log.Println("Date yesterday:", dateYesterday)
+	l.Info("Removing tags created before yesterday", "today", dateNow, "yesterday", dateYesterday)
 	for _, destinationRepo := range destinationFilteredRepos {
-		log.Println("Processing destination repo:", destinationRepo)
+		l.Info("Processing destination repo", "repo", destinationRepo)
 		var filteredDestinationTags []string
 		var repoProdFound bool
 		for _, prodRepo := range sourceProdRepos {
@@ -50,58 +43,51 @@ func Clean(reposLimit string, sourceFilteredRepos []string, destinationFilteredR
 				break
 			}
 		}
-		destinationRepoTags, err := listTags(destinationRegistry, destinationRepo, creds.DestinationUser, creds.DestinationPassword)
+		destinationRepoTags, err := listTags(destinationRegistry, destinationRepo, creds.DestinationUser, creds.DestinationPassword, job.Docker.PageSize)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("listing tags of %s/%s: %w", destinationRegistry, destinationRepo, err)
 		}
 		if repoProdFound {
-			sourceProdRepoTags, err := listTags(sourceProdRegistry, destinationRepo, prodSourceRegistryUser, prodSourceRegistryPassword)
+			sourceProdRepoTags, err := listTags(sourceProdRegistry, destinationRepo, prodSourceRegistryUser, prodSourceRegistryPassword, job.Docker.PageSize)
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("listing tags of %s/%s: %w", sourceProdRegistry, destinationRepo, err)
 			}
 			for _, destinationTag := range destinationRepoTags {
 				var tagFound bool
 				for _, sourceProdTag := range sourceProdRepoTags {
 					if destinationTag == sourceProdTag {
-						log.Println("Found tag on prod source and destination:", destinationRepo+":"+destinationTag)
+						l.Debug("Found tag on prod source and destination", "repo", destinationRepo, "tag", destinationTag)
 						tagFound = true
 						break
 					}
 				}
-				if !tagFound {
+				if tagFound {
+					p.Add(plan.Action{Job: job.Name, Op: plan.OpSkip, Name: destinationRepo + ":" + destinationTag, Reason: "in prod", Repo: destinationRepo, Ref: destinationTag})
+				} else {
 					filteredDestinationTags = append(filteredDestinationTags, destinationTag)
 				}
 			}
 		} else {
 			filteredDestinationTags = destinationRepoTags
 		}
-		timeTags := make(map[string]string)
 		for _, destinationTag := range filteredDestinationTags {
 			tagUploadDateTime, err := GetCreateTime(destinationRegistry, destinationRepo, destinationTag, creds.DestinationUser, creds.DestinationPassword)
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("getting creation time of %s/%s:%s: %w", destinationRegistry, destinationRepo, destinationTag, err)
 			}
-			//log.Println("Getting tag creation time:", destinationRegistry+"/"+destinationRepo+":"+destinationTag, tagUploadDate)
+			l.Debug("Got tag creation time", "repo", destinationRepo, "tag", destinationTag, "created", tagUploadDateTime)
 			s := strings.Split(tagUploadDateTime, "T")
 			tagUploadDate := s[0]
-			timeTags[destinationTag] = tagUploadDate
-		}
-
-		for k, v := range timeTags {
-			if v != dateNow && v != dateYesterday {
-				log.Println("Removing tag:", k, v)
-				err := dockerRemoveTag(destinationRegistry, destinationRepo, k, destinationRegistryType, creds.DestinationUser, creds.DestinationPassword)
-				if err != nil {
-					panic(err)
-				}
-				RemovedTags++
+			action := plan.Action{Job: job.Name, Name: destinationRepo + ":" + destinationTag, Repo: destinationRepo, Ref: destinationTag}
+			if tagUploadDate != dateNow && tagUploadDate != dateYesterday {
+				l.Info("Planning tag removal", "repo", destinationRepo, "tag", destinationTag, "created", tagUploadDate)
+				action.Op, action.Reason = plan.OpDelete, "not in prod, created "+tagUploadDate
 			} else {
-				log.Println("Keeping tag:", k, v)
-				SkippedTags++
+				l.Info("Keeping tag", "repo", destinationRepo, "tag", destinationTag, "created", tagUploadDate)
+				action.Op, action.Reason = plan.OpSkip, "created today or yesterday"
 			}
-
+			p.Add(action)
 		}
 	}
-	log.Println("Removed", RemovedTags, "tags")
-	log.Println("Skipped", SkippedTags, "tags")
+	return nil
 }
@@ -0,0 +1,275 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+)
+
+// blobMounts remembers a destination repo already holding each blob, by digest, to mount it instead of uploading again.
+// It is shared by the images of one run of a job, which all go to the same destination registry.
+type blobMounts struct {
+	sync.Mutex
+	repos map[string]string
+}
+
+func newBlobMounts() *blobMounts {
+	return &blobMounts{repos: make(map[string]string)}
+}
+
+// repo returns the repo known to hold the blob, or an empty string
+func (m *blobMounts) repo(digest string) string {
+	if m == nil {
+		return ""
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.repos[digest]
+}
+
+// remember records that the repo holds the blob
+func (m *blobMounts) remember(digest string, repo string) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.repos[digest] = repo
+}
+
+func computeDigest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// copyImage copies the image manifest and blobs between registries over the Registry v2 API, without a docker daemon.
+// Manifest lists and OCI indexes are copied whole, with every child manifest, unless image.Platforms limits them.
+// It returns the number of blob bytes uploaded.
+func copyImage(image ImageToReplicate, creds credentials.Creds) (int64, error) {
+	defer metrics.ObserveOperation("copy", time.Now())
+	destinationImage := image.DestinationRegistry + "/" + image.DestinationImage + ":" + image.DestinationTag
+	l := image.logger()
+	l.Info("Copying image over the registry API", "to", destinationImage)
+	source := newRegistryClient(image.SourceRegistry, creds.SourceUser, creds.SourcePassword)
+	destination := newRegistryClient(image.DestinationRegistry, creds.DestinationUser, creds.DestinationPassword)
+	manifest, mediaType, digest, err := source.getManifest(image.SourceImage, image.SourceTag, manifestMediaTypes)
+	if err != nil {
+		return 0, err
+	}
+	var bytes int64
+	if isIndex(mediaType) {
+		manifest, bytes, err = copyIndexChildren(source, destination, image, manifest)
+		if err != nil {
+			return bytes, err
+		}
+		if computeDigest(manifest) != digest {
+			l.Info("Index was filtered by platform, destination digest will differ", "digest", digest)
+		}
+	} else {
+		bytes, err = copyManifestBlobs(source, destination, image, mediaType, manifest)
+		if err != nil {
+			return bytes, err
+		}
+	}
+	return bytes, destination.putManifest(image.DestinationImage, image.DestinationTag, mediaType, manifest)
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == mediaTypeDockerManifestList || mediaType == mediaTypeOCIIndex
+}
+
+// copyIndexChildren copies every child manifest of the index, pushed by digest, and returns the index to push.
+// The index is returned unchanged unless some children are excluded by image.Platforms.
+func copyIndexChildren(source *registryClient, destination *registryClient, image ImageToReplicate, manifest []byte) ([]byte, int64, error) {
+	filtered, children, err := filterIndex(manifest, image.Platforms)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(children) == 0 {
+		return nil, 0, errors.New("no manifests of " + image.SourceImage + ":" + image.SourceTag + " match platforms " + strings.Join(image.Platforms, ","))
+	}
+	var bytes int64
+	for _, child := range children {
+		childManifest, childMediaType, _, err := source.getManifest(image.SourceImage, child.Digest, manifestMediaTypes)
+		if err != nil {
+			return nil, bytes, err
+		}
+		var childBytes int64
+		if isIndex(childMediaType) {
+			childManifest, childBytes, err = copyIndexChildren(source, destination, image, childManifest)
+		} else {
+			childBytes, err = copyManifestBlobs(source, destination, image, childMediaType, childManifest)
+		}
+		bytes += childBytes
+		if err != nil {
+			return nil, bytes, err
+		}
+		err = destination.putManifest(image.DestinationImage, child.Digest, childMediaType, childManifest)
+		if err != nil {
+			return nil, bytes, err
+		}
+	}
+	return filtered, bytes, nil
+}
+
+// filterIndex returns the index with only the children matching platforms, and those children.
+// The index bytes are returned unchanged when nothing is filtered out, to keep the digest.
+func filterIndex(manifest []byte, platforms []string) ([]byte, []descriptor, error) {
+	var index indexManifest
+	err := json.Unmarshal(manifest, &index)
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw struct {
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	err = json.Unmarshal(manifest, &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	var kept []json.RawMessage
+	var children []descriptor
+	for i, child := range index.Manifests {
+		if !platformAllowed(child.Platform, platforms) {
+			logger.Debug("Skipping platform", "platform", platformString(child.Platform), "digest", child.Digest)
+			continue
+		}
+		kept = append(kept, raw.Manifests[i])
+		children = append(children, child.descriptor)
+	}
+	if len(kept) == len(raw.Manifests) || len(kept) == 0 {
+		return manifest, children, nil
+	}
+	var filtered map[string]json.RawMessage
+	err = json.Unmarshal(manifest, &filtered)
+	if err != nil {
+		return nil, nil, err
+	}
+	filtered["manifests"], err = json.Marshal(kept)
+	if err != nil {
+		return nil, nil, err
+	}
+	filteredManifest, err := json.Marshal(filtered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return filteredManifest, children, nil
+}
+
+func platformString(p *platform) string {
+	if p == nil {
+		return "unknown"
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// platformAllowed matches the platform against os/arch or os/arch/variant entries, an empty list allows all platforms
+func platformAllowed(p *platform, platforms []string) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, allowed := range platforms {
+		if allowed == p.OS+"/"+p.Architecture || allowed == platformString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyManifestBlobs(source *registryClient, destination *registryClient, image ImageToReplicate, mediaType string, manifest []byte) (int64, error) {
+	if mediaType != mediaTypeDockerManifest && mediaType != mediaTypeOCIManifest {
+		return 0, errors.New("unsupported manifest media type " + mediaType + " for " + image.SourceImage + ":" + image.SourceTag)
+	}
+	var m imageManifest
+	err := json.Unmarshal(manifest, &m)
+	if err != nil {
+		return 0, err
+	}
+	var bytes int64
+	blobs := append([]descriptor{m.Config}, m.Layers...)
+	for _, blob := range blobs {
+		if len(blob.URLs) != 0 {
+			image.logger().Debug("Skipping foreign layer", "digest", blob.Digest)
+			continue
+		}
+		blobBytes, err := copyBlob(source, destination, image, blob)
+		bytes += blobBytes
+		if err != nil {
+			return bytes, err
+		}
+	}
+	return bytes, nil
+}
+
+// isManifestList checks whether the source tag is a manifest list or OCI index, which the docker daemon can't copy whole
+func isManifestList(image ImageToReplicate, creds credentials.Creds) (bool, error) {
+	source := newRegistryClient(image.SourceRegistry, creds.SourceUser, creds.SourcePassword)
+	mediaType, _, err := source.headManifest(image.SourceImage, image.SourceTag)
+	if err != nil {
+		return false, err
+	}
+	return isIndex(mediaType), nil
+}
+
+// copyBlob copies the blob unless the destination already has it, returning the number of bytes uploaded
+func copyBlob(source *registryClient, destination *registryClient, image ImageToReplicate, blob descriptor) (int64, error) {
+	exists, err := destination.blobExists(image.DestinationImage, blob.Digest)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		image.logger().Debug("Blob exists at destination", "digest", blob.Digest)
+		image.mounts.remember(blob.Digest, image.DestinationImage)
+		return 0, nil
+	}
+	var location string
+	fromRepo := image.mounts.repo(blob.Digest)
+	if fromRepo != "" && fromRepo != image.DestinationImage {
+		var mounted bool
+		mounted, location, err = destination.mountBlob(image.DestinationImage, blob.Digest, fromRepo)
+		if err != nil {
+			return 0, err
+		}
+		if mounted {
+			image.logger().Debug("Mounted blob", "digest", blob.Digest, "from", fromRepo)
+			image.mounts.remember(blob.Digest, image.DestinationImage)
+			return 0, nil
+		}
+	}
+	if location == "" {
+		location, err = destination.startUpload(image.DestinationImage)
+		if err != nil {
+			return 0, err
+		}
+	}
+	body, size, err := source.getBlob(image.SourceImage, blob.Digest)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	if size < 0 {
+		size = blob.Size
+	}
+	image.logger().Debug("Uploading blob", "digest", blob.Digest, "bytes", size)
+	err = destination.putBlob(location, blob.Digest, body, size)
+	if err != nil {
+		return 0, err
+	}
+	image.mounts.remember(blob.Digest, image.DestinationImage)
+	return size, nil
+}
@@ -2,14 +2,15 @@ package docker
 
 import (
 	"context"
-	"log"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+
+	"github.com/loqutus/artifactory-replication/pkg/logger"
 )
 
 func DeleteImage(imageName string) error {
-	log.Println("Deleting local image:" + imageName)
+	logger.Debug("Deleting local image", "image", imageName)
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"github.com/loqutus/artifactory-replication/pkg/credentials"
+)
+
+// DOCKER_TAG_POLICY values, deciding what to do with tags already present at destination
+const (
+	// tagPolicyName skips tags present at destination, without comparing them
+	tagPolicyName = "name"
+	// tagPolicyDigest copies tags again when the source and destination manifest digests differ
+	tagPolicyDigest = "digest"
+	// tagPolicyImmutable never overwrites destination tags, only logging the digest drift
+	tagPolicyImmutable = "immutable"
+)
+
+// tagDigests returns the manifest digest the destination tag should have, and the one it has
+func tagDigests(image ImageToReplicate, destinationRepo string, creds credentials.Creds) (string, string, error) {
+	source := newRegistryClient(image.SourceRegistry, creds.SourceUser, creds.SourcePassword)
+	destination := newRegistryClient(image.DestinationRegistry, creds.DestinationUser, creds.DestinationPassword)
+	mediaType, sourceDigest, err := source.headManifest(image.SourceImage, image.SourceTag)
+	if err != nil {
+		return "", "", err
+	}
+	if isIndex(mediaType) && len(image.Platforms) != 0 {
+		// the destination holds the index filtered by platform, which has its own digest
+		manifest, _, _, err := source.getManifest(image.SourceImage, image.SourceTag, manifestMediaTypes)
+		if err != nil {
+			return "", "", err
+		}
+		filtered, _, err := filterIndex(manifest, image.Platforms)
+		if err != nil {
+			return "", "", err
+		}
+		sourceDigest = computeDigest(filtered)
+	}
+	_, destinationDigest, err := destination.headManifest(destinationRepo, image.DestinationTag)
+	if err != nil {
+		return "", "", err
+	}
+	return sourceDigest, destinationDigest, nil
+}
+
+// tagChanged tells whether a tag present at destination should be replicated again according to the tag policy, and why
+func tagChanged(image ImageToReplicate, destinationRepo string, creds credentials.Creds, tagPolicy string) (bool, string, error) {
+	if tagPolicy != tagPolicyDigest && tagPolicy != tagPolicyImmutable {
+		return false, "present at destination", nil
+	}
+	sourceDigest, destinationDigest, err := tagDigests(image, destinationRepo, creds)
+	if err != nil {
+		return false, "", err
+	}
+	if sourceDigest == destinationDigest {
+		return false, "same digest at destination", nil
+	}
+	l := image.logger().With("digest", sourceDigest, "destination_digest", destinationDigest)
+	if tagPolicy == tagPolicyImmutable {
+		l.Warn("Repo tag digest differs at destination, not overwriting immutable tag")
+		return false, "immutable tag with different digest at destination", nil
+	}
+	l.Info("Repo tag digest differs at destination, replicating")
+	return true, "digest differs at destination", nil
+}
@@ -2,56 +2,41 @@ package docker
 
 import (
 	"encoding/json"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"strings"
 	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
 )
 
-func GetRepos(dockerRegistry string, user string, pass string, reposLimit string) ([]string, error) {
-	client := &http.Client{}
-	url := "https://" + dockerRegistry + "/v2/_catalog?n=" + reposLimit
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+const defaultPageSize = 1000
+
+// pageSize returns the page size for catalog and tag listings, defaultPageSize if not set
+func pageSize(n int) int {
+	if n <= 0 {
+		return defaultPageSize
 	}
-	req.SetBasicAuth(user, pass)
-	var resp *http.Response
-	var failed bool
-	backOffTime := backOffStart
-	var body []byte
-	for i := 1; i <= backOffSteps; i++ {
-		resp, err = client.Do(req)
+	return n
+}
+
+// GetRepos lists the registry catalog, n repos per page, defaultPageSize when n is 0
+func GetRepos(dockerRegistry string, user string, pass string, n int) ([]string, error) {
+	defer metrics.ObserveOperation("list", time.Now())
+	client := newRegistryClient(dockerRegistry, user, pass)
+	var repos []string
+	err := client.getPages(httpclient.URL(dockerRegistry)+"/v2/_catalog", pageSize(n), func(body []byte) error {
+		type res struct {
+			Repositories []string
+		}
+		var b res
+		err := json.Unmarshal(body, &b)
 		if err != nil {
-			failed = true
-			log.Print("error HTTP GET", url, "retry", string(i))
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			defer resp.Body.Close()
-			failed = false
-			body, err = ioutil.ReadAll(resp.Body)
-			if err != nil || strings.Contains(string([]byte(body)), "errors") {
-				failed = true
-				log.Print("error HTTP GET", url, "retry", string(i))
-				backOffTime *= i
-			}
-			break
+			return err
 		}
-	}
-	if failed == true {
-		return nil, err
-	}
-	type res struct {
-		Repositories []string
-	}
-	var b res
-	err = json.Unmarshal(body, &b)
+		repos = append(repos, b.Repositories...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return b.Repositories, nil
+	return repos, nil
 }
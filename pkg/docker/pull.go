@@ -7,19 +7,20 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
-	"io/ioutil"
-	"log"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
 func pullImage(image ImageToReplicate, creds credentials.Creds) error {
+	defer metrics.ObserveOperation("pull", time.Now())
 	sourceImage := image.SourceRegistry + "/" + image.SourceImage + ":" + image.SourceTag
-	log.Println("Pulling " + sourceImage)
+	image.logger().Info("Pulling image")
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -27,6 +28,7 @@ func pullImage(image ImageToReplicate, creds credentials.Creds) error {
 	}
 	defer cli.Close()
 	cli.NegotiateAPIVersion(ctx)
+	var options types.ImagePullOptions
 	if creds.SourceUser != "" || creds.SourcePassword != "" {
 		authConfig := types.AuthConfig{
 			Username: creds.SourceUser,
@@ -36,64 +38,22 @@ func pullImage(image ImageToReplicate, creds credentials.Creds) error {
 		if err != nil {
 			return err
 		}
-		authStr := base64.URLEncoding.EncodeToString(encodedJSON)
-		var failed bool
-		backOffTime := backOffStart
-		var out io.ReadCloser
-		for i := 1; i <= backOffSteps; i++ {
-			out, err = cli.ImagePull(ctx, sourceImage, types.ImagePullOptions{RegistryAuth: authStr})
-			if err != nil {
-				failed = true
-				log.Print("error pulling image", sourceImage, "retry", string(i))
-				if i != backOffSteps {
-					time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-				}
-				backOffTime *= i
-			} else {
-				defer out.Close()
-				failed = false
-				break
-			}
-		}
-		if failed == true {
-			return err
-		}
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(out)
-		newStr := buf.String()
-		if strings.Contains(newStr, "error") || strings.Contains(newStr, "Error") {
-			return errors.New(newStr)
-		}
-		io.Copy(ioutil.Discard, out)
-	} else {
-		var failed bool
-		backOffTime := backOffStart
-		var out io.ReadCloser
-		for i := 1; i <= backOffSteps; i++ {
-			out, err = cli.ImagePull(ctx, sourceImage, types.ImagePullOptions{})
-			if err != nil {
-				failed = true
-				log.Print("error pulling image", sourceImage, "retry", string(i))
-				if i != backOffSteps {
-					time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-				}
-				backOffTime *= i
-			} else {
-				failed = false
-				break
-			}
-		}
-		if failed == true {
-			return err
-		}
-		defer out.Close()
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(out)
-		newStr := buf.String()
-		if strings.Contains(newStr, "error") || strings.Contains(newStr, "Error") {
-			return errors.New(newStr)
-		}
-		io.Copy(ioutil.Discard, out)
+		options.RegistryAuth = base64.URLEncoding.EncodeToString(encodedJSON)
+	}
+	var out io.ReadCloser
+	err = retry.Do(ctx, "pulling image "+sourceImage, func() error {
+		out, err = cli.ImagePull(ctx, sourceImage, options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(out)
+	newStr := buf.String()
+	if strings.Contains(newStr, "error") || strings.Contains(newStr, "Error") {
+		return errors.New(newStr)
 	}
 	return nil
 }
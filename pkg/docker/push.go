@@ -7,18 +7,20 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
 func pushImage(image ImageToReplicate, creds credentials.Creds) error {
+	defer metrics.ObserveOperation("push", time.Now())
 	destinationImage := image.DestinationRegistry + "/" + image.DestinationImage + ":" + image.DestinationTag
-	log.Println("Pushing " + destinationImage)
+	image.logger().Info("Pushing image", "to", destinationImage)
 	sourceImage := image.SourceRegistry + "/" + image.SourceImage + ":" + image.SourceTag
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv)
@@ -31,6 +33,7 @@ func pushImage(image ImageToReplicate, creds credentials.Creds) error {
 	if err != nil {
 		return err
 	}
+	var options types.ImagePushOptions
 	if creds.DestinationUser != "" || creds.DestinationPassword != "" {
 		authConfig := types.AuthConfig{
 			Username: creds.DestinationUser,
@@ -40,62 +43,22 @@ func pushImage(image ImageToReplicate, creds credentials.Creds) error {
 		if err != nil {
 			return err
 		}
-		authStr := base64.URLEncoding.EncodeToString(encodedJSON)
-		var failed bool
-		backOffTime := backOffStart
-		var out io.ReadCloser
-		for i := 1; i <= backOffSteps; i++ {
-			out, err = cli.ImagePush(ctx, destinationImage, types.ImagePushOptions{RegistryAuth: authStr})
-			if err != nil {
-				failed = true
-				log.Print("error pushing image", sourceImage, "retry", string(i))
-				if i != backOffSteps {
-					time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-				}
-				backOffTime *= i
-			} else {
-				defer out.Close()
-				failed = false
-				break
-			}
-		}
-		if failed == true {
-			return err
-		}
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(out)
-		newStr := buf.String()
-		if strings.Contains(newStr, "error") || strings.Contains(newStr, "Error") {
-			return errors.New(newStr)
-		}
-	} else {
-		var failed bool
-		backOffTime := backOffStart
-		var out io.ReadCloser
-		for i := 1; i <= backOffSteps; i++ {
-			out, err = cli.ImagePush(ctx, destinationImage, types.ImagePushOptions{})
-			defer out.Close()
-			if err != nil {
-				failed = true
-				log.Print("error pushing image", sourceImage, "retry", string(i))
-				if i != backOffSteps {
-					time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-				}
-				backOffTime *= i
-			} else {
-				failed = false
-				break
-			}
-		}
-		if failed == true {
-			return err
-		}
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(out)
-		newStr := buf.String()
-		if strings.Contains(newStr, "error") || strings.Contains(newStr, "Error") {
-			return errors.New(newStr)
-		}
+		options.RegistryAuth = base64.URLEncoding.EncodeToString(encodedJSON)
+	}
+	var out io.ReadCloser
+	err = retry.Do(ctx, "pushing image "+destinationImage, func() error {
+		out, err = cli.ImagePush(ctx, destinationImage, options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(out)
+	newStr := buf.String()
+	if strings.Contains(newStr, "error") || strings.Contains(newStr, "Error") {
+		return errors.New(newStr)
 	}
 	return nil
 }
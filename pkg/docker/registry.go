@@ -0,0 +1,368 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+var manifestMediaTypes = []string{
+	mediaTypeDockerManifestList,
+	mediaTypeOCIIndex,
+	mediaTypeDockerManifest,
+	mediaTypeOCIManifest,
+}
+
+// registryClient talks to a docker registry using the Registry v2 HTTP API
+type registryClient struct {
+	registry string
+	user     string
+	pass     string
+	client   *http.Client
+}
+
+// descriptor references a blob or manifest by digest
+type descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	Size      int64    `json:"size"`
+	URLs      []string `json:"urls,omitempty"`
+}
+
+// platform of a manifest list or OCI index entry
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// indexManifest is the part of a docker manifest list or OCI image index needed to copy its children
+type indexManifest struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		descriptor
+		Platform *platform `json:"platform,omitempty"`
+	} `json:"manifests"`
+}
+
+// imageManifest is the part of a docker schema2 or OCI image manifest needed to copy its blobs
+type imageManifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+func newRegistryClient(registry string, user string, pass string) *registryClient {
+	return &registryClient{
+		registry: registry,
+		user:     user,
+		pass:     pass,
+		client:   newAuthClient(registry, user, pass),
+	}
+}
+
+func (c *registryClient) url(repo string, path string) string {
+	return httpclient.URL(c.registry) + "/v2/" + repo + "/" + path
+}
+
+// do sends the request built by newReq, retrying on transport errors, 429 and 5xx responses
+func (c *registryClient) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	var resp *http.Response
+	err := retry.Do(context.Background(), "calling registry "+c.registry, func() error {
+		req, err := newReq()
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		r, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		if retry.RetryableStatus(r.StatusCode) {
+			return retry.StatusError(r)
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return retry.NewHTTPError(resp.StatusCode, resp.Request.Method+" "+resp.Request.URL.String()+": "+resp.Status+": "+strings.TrimSpace(string(body)))
+}
+
+// getPages calls page with the body of every page of a paginated listing,
+// following the RFC 5988 Link rel="next" header until the listing is complete
+func (c *registryClient) getPages(listURL string, pageSize int, page func(body []byte) error) error {
+	u, err := url.Parse(listURL)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("n", strconv.Itoa(pageSize))
+	u.RawQuery = query.Encode()
+	for u != nil {
+		pageURL := u.String()
+		resp, err := c.do(func() (*http.Request, error) {
+			return http.NewRequest(http.MethodGet, pageURL, nil)
+		})
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := responseError(resp)
+			resp.Body.Close()
+			return err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		err = page(body)
+		if err != nil {
+			return err
+		}
+		next := nextLink(resp.Header)
+		if next == "" {
+			break
+		}
+		u, err = u.Parse(next)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextLink returns the target of the Link header with rel="next", if any
+func nextLink(header http.Header) string {
+	for _, link := range header["Link"] {
+		for _, part := range strings.Split(link, ",") {
+			fields := strings.Split(part, ";")
+			target := strings.TrimSpace(fields[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range fields[1:] {
+				param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+				if param == `rel="next"` || param == "rel=next" {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// getManifest returns the raw manifest, its media type and digest
+func (c *registryClient) getManifest(repo string, reference string, accept []string) ([]byte, string, string, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, c.url(repo, "manifests/"+reference), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+		return req, nil
+	})
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", responseError(resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i != -1 {
+		mediaType = mediaType[:i]
+	}
+	var m struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(body, &m); err == nil && m.MediaType != "" {
+		mediaType = m.MediaType
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = computeDigest(body)
+	}
+	return body, mediaType, digest, nil
+}
+
+// headManifest returns the manifest media type and digest of the reference using a HEAD request
+func (c *registryClient) headManifest(repo string, reference string) (string, string, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodHead, c.url(repo, "manifests/"+reference), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		return req, nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", retry.NewHTTPError(resp.StatusCode, "HEAD "+c.url(repo, "manifests/"+reference)+": "+resp.Status)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i != -1 {
+		mediaType = mediaType[:i]
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		// some registries only return the digest on GET
+		_, mediaType, digest, err = c.getManifest(repo, reference, manifestMediaTypes)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return mediaType, digest, nil
+}
+
+// putManifest uploads the manifest bytes unchanged, so the destination digest matches the source one
+func (c *registryClient) putManifest(repo string, reference string, mediaType string, manifest []byte) error {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, c.url(repo, "manifests/"+reference), bytes.NewReader(manifest))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (c *registryClient) blobExists(repo string, digest string) (bool, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, c.url(repo, "blobs/"+digest), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, retry.NewHTTPError(resp.StatusCode, "HEAD "+c.url(repo, "blobs/"+digest)+": "+resp.Status)
+}
+
+func (c *registryClient) getBlob(repo string, digest string) (io.ReadCloser, int64, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, c.url(repo, "blobs/"+digest), nil)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, 0, responseError(resp)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// mountBlob tries to mount the blob from another repository of the same registry.
+// When the registry doesn't support mounting, it returns the upload location to use instead.
+func (c *registryClient) mountBlob(repo string, digest string, fromRepo string) (bool, string, error) {
+	query := url.Values{}
+	query.Set("mount", digest)
+	query.Set("from", fromRepo)
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, c.url(repo, "blobs/uploads/")+"?"+query.Encode(), nil)
+	})
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, "", nil
+	case http.StatusAccepted:
+		location, err := c.location(resp)
+		return false, location, err
+	}
+	return false, "", responseError(resp)
+}
+
+func (c *registryClient) startUpload(repo string) (string, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, c.url(repo, "blobs/uploads/"), nil)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", responseError(resp)
+	}
+	return c.location(resp)
+}
+
+func (c *registryClient) location(resp *http.Response) (string, error) {
+	location, err := resp.Location()
+	if err != nil {
+		return "", err
+	}
+	return location.String(), nil
+}
+
+// putBlob finishes the upload started at location with a single monolithic PUT.
+// The body is streamed and can't be replayed, so the request isn't retried.
+func (c *registryClient) putBlob(location string, digest string, body io.Reader, size int64) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	return nil
+}
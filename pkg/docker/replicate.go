@@ -1,21 +1,19 @@
 package docker
 
 import (
-	"log"
-	"os"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/loqutus/artifactory-replication/pkg/config"
 	"github.com/loqutus/artifactory-replication/pkg/credentials"
-	"github.com/loqutus/artifactory-replication/pkg/slack"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/plan"
+	"github.com/loqutus/artifactory-replication/pkg/pool"
+	"github.com/loqutus/artifactory-replication/pkg/result"
 )
 
-var FailedPullRepos []string
-var FailedPushRepos []string
-var FailedCleanRepos []string
-
 // ImageToReplicate source/desination image parameters
 type ImageToReplicate struct {
 	SourceRegistry      string
@@ -24,130 +22,165 @@ type ImageToReplicate struct {
 	DestinationImage    string
 	SourceTag           string
 	DestinationTag      string
+	// Platforms limits which manifests of a multi-arch image are copied, as os/arch or os/arch/variant; all when empty
+	Platforms []string
+	// Job is the name of the job replicating the image, for the log fields
+	Job string
+	// mounts are the blobs copied by the run of the job, mounted instead of uploaded again when possible
+	mounts *blobMounts
 }
 
-func doReplicateDocker(image ImageToReplicate, creds credentials.Creds, destinationRegistryType string, repoFound *bool, dockerRepoPrefix string) error {
-	err := pullImage(image, creds)
-	if err != nil {
-		log.Println(err)
-		log.Println("Error pulling image, ignoring...")
-		FailedPullRepos = append(FailedPullRepos, image.SourceImage+":"+image.SourceTag)
-		return nil
-	}
-	if destinationRegistryType == "aws" && *repoFound == false {
-		log.Println("Creating destination repo: " + image.DestinationImage)
-		input := ecr.CreateRepositoryInput{
-			RepositoryName: &image.DestinationImage,
+// logger returns a logger with the job, registry, repo and tag fields of the image
+func (i ImageToReplicate) logger() *logger.Logger {
+	return logger.With("job", i.Job, "source", i.SourceRegistry, "destination", i.DestinationRegistry, "repo", i.SourceImage, "tag", i.SourceTag)
+}
+
+// doReplicateDocker copies one image, recording it as copied or failed in res
+func doReplicateDocker(image ImageToReplicate, creds credentials.Creds, destinationRegistryType string, dockerRepoPrefix string, copyMode string, res *result.Result) {
+	started := time.Now()
+	l := image.logger()
+	sourceName := image.SourceImage + ":" + image.SourceTag
+	if copyMode != "registry" {
+		multiArch, err := isManifestList(image, creds)
+		if err != nil {
+			l.Warn("Error getting image manifest, ignoring", "err", err)
+			res.AddFailed(sourceName, "pull", err)
+			return
+		}
+		if multiArch {
+			l.Info("Image is multi-arch, copying it over the registry API")
+			copyMode = "registry"
 		}
-		sess, _ := session.NewSession(&aws.Config{})
-		svc := ecr.New(sess)
-		output, err := svc.CreateRepository(&input)
+	}
+	if copyMode != "registry" {
+		err := pullImage(image, creds)
 		if err != nil {
-			if !strings.Contains(err.Error(), "already exists") {
-				log.Println(output.String())
-				return err
-			}
+			l.Warn("Error pulling image, ignoring", "err", err)
+			res.AddFailed(sourceName, "pull", err)
+			return
 		}
-		*repoFound = true
-	} else if destinationRegistryType == "alicloud" || destinationRegistryType == "google" {
+	}
+	if destinationRegistryType == "alicloud" || destinationRegistryType == "google" {
 		if dockerRepoPrefix != "" {
 			image.DestinationImage = dockerRepoPrefix + "/" + image.DestinationImage
 		}
 	}
+	destinationName := image.DestinationImage + ":" + image.DestinationTag
+	if copyMode == "registry" {
+		bytes, err := copyImage(image, creds)
+		if err != nil {
+			l.Warn("Error copying image, ignoring", "err", err)
+			res.AddFailed(destinationName, "push", err)
+			return
+		}
+		res.AddCopied(result.Item{Name: destinationName, Op: "copy", Bytes: bytes, Duration: time.Since(started)})
+		l.Info("Image copied", "bytes", bytes, "duration", time.Since(started))
+		return
+	}
 	destinationImage := image.DestinationRegistry + "/" + image.DestinationImage + ":" + image.DestinationTag
 	sourceImage := image.SourceRegistry + "/" + image.SourceImage + ":" + image.SourceTag
-	err = pushImage(image, creds)
+	err := pushImage(image, creds)
 	if err != nil {
-		log.Println(err)
-		log.Println("Error pushing image, ignoring...")
-		FailedPushRepos = append(FailedPushRepos, image.DestinationImage+":"+image.DestinationTag)
-		return nil
+		l.Warn("Error pushing image, ignoring", "err", err)
+		res.AddFailed(destinationName, "push", err)
+		return
 	}
+	res.AddCopied(result.Item{Name: destinationName, Op: "push", Duration: time.Since(started)})
+	l.Info("Image pushed", "duration", time.Since(started))
 	err = DeleteImage(sourceImage)
 	if err != nil {
-		log.Println(err)
-		log.Println("Error deleting local image", sourceImage, ", ignoring...")
-		FailedCleanRepos = append(FailedCleanRepos, image.SourceImage+":"+image.SourceTag)
-		return nil
+		l.Warn("Error deleting local image, ignoring", "image", sourceImage, "err", err)
+		res.AddFailed(sourceName, "clean", err)
+		return
 	}
 	err = DeleteImage(destinationImage)
 	if err != nil {
-		log.Println(err)
-		log.Println("error deleting local image ", destinationImage, ", ignoring...")
-		FailedCleanRepos = append(FailedCleanRepos, image.DestinationImage+":"+image.DestinationTag)
-		return nil
+		l.Warn("Error deleting local image, ignoring", "image", destinationImage, "err", err)
+		res.AddFailed(destinationName, "clean", err)
 	}
-	return nil
 }
 
-func Replicate(creds credentials.Creds, sourceRegistry string, destinationRegistry string, artifactFilter string, destinationRegistryType string) {
-	var copiedArtifacts uint = 0
-	var reposLimit string
-	if destinationRegistryType == "aws" {
-		reposLimit = "1000"
-	} else {
-		reposLimit = "1000000"
+// Replicate copies the tags of the job source repos missing at destination, or cleans the destination when the job cleanup is enabled
+func Replicate(job config.Job) (*result.Result, error) {
+	p := plan.New()
+	err := Plan(job, p)
+	if err != nil {
+		res := result.New()
+		res.Finish()
+		return res, err
 	}
-	log.Println("Getting repos from source registry: " + sourceRegistry)
-	sourceRepos, err := GetRepos(sourceRegistry, creds.SourceUser, creds.SourcePassword, reposLimit)
+	return Apply(job, p.Job(job.Name))
+}
+
+// Plan adds to p what Replicate would do: the tags to copy, overwrite or skip, or to delete when the job cleanup is enabled.
+// It only reads from the registries.
+func Plan(job config.Job, p *plan.Plan) error {
+	err := job.Validate()
 	if err != nil {
-		err2 := slack.SendMessage(err.Error())
-		if err2 != nil {
-			log.Println(err)
-			panic(err2)
-		}
-		panic(err)
+		return err
 	}
-	log.Println("Found source repos: ", len(sourceRepos))
-	log.Println("Getting repos from destination from destination registry: " + destinationRegistry)
-	destinationRepos, err := GetRepos(destinationRegistry, creds.DestinationUser, creds.DestinationPassword, reposLimit)
+	creds := job.Creds()
+	sourceRegistry := job.Source.Registry
+	destinationRegistry := job.Destination.Registry
+	destinationRegistryType := job.Destination.Type
+	artifactFilter := job.Filter
+	dockerRepoPrefix := job.Docker.RepoPrefix
+	dockerTag := job.Docker.Tag
+	tagPolicy := job.Docker.TagPolicy
+	if tagPolicy == "" {
+		tagPolicy = tagPolicyName
+	}
+	l := logger.Job(job)
+	l.Info("Getting repos from source registry")
+	sourceRepos, err := GetRepos(sourceRegistry, creds.SourceUser, creds.SourcePassword, job.Docker.PageSize)
 	if err != nil {
-		err2 := slack.SendMessage(err.Error())
-		if err2 != nil {
-			log.Println(err)
-			panic(err2)
-		}
-		panic(err)
+		return fmt.Errorf("listing repos of %s: %w", sourceRegistry, err)
+	}
+	l.Info("Found source repos", "count", len(sourceRepos))
+	l.Info("Getting repos from destination registry")
+	destinationRepos, err := GetRepos(destinationRegistry, creds.DestinationUser, creds.DestinationPassword, job.Docker.PageSize)
+	if err != nil {
+		return fmt.Errorf("listing repos of %s: %w", destinationRegistry, err)
 	}
-	log.Println("Found destination repos: ", len(destinationRepos))
-	dockerRepoPrefix := os.Getenv("DOCKER_REPO_PREFIX")
-	dockerTag := os.Getenv("DOCKER_TAG")
+	l.Info("Found destination repos", "count", len(destinationRepos))
+	// repo narrows the run to one repo, e.g. the one of a webhook event
+	repo := job.Docker.Repo
 	sourceFilteredRepos := sourceRepos[:0]
-	if artifactFilter != "" {
+	if artifactFilter != "" || repo != "" {
 		for _, sourceRepo := range sourceRepos {
-			if strings.HasPrefix(sourceRepo, artifactFilter) {
+			if strings.HasPrefix(sourceRepo, artifactFilter) && (repo == "" || sourceRepo == repo) {
 				sourceFilteredRepos = append(sourceFilteredRepos, sourceRepo)
 			}
 		}
 	} else {
 		sourceFilteredRepos = sourceRepos
 	}
-	log.Println("Found filtered source repos: ", len(sourceFilteredRepos))
+	l.Info("Found filtered source repos", "count", len(sourceFilteredRepos))
 	destinationFilteredRepos := destinationRepos[:0]
-	if artifactFilter != "" {
+	if artifactFilter != "" || repo != "" {
 		for _, sourceRepo := range destinationRepos {
-			if strings.HasPrefix(sourceRepo, artifactFilter) {
+			name := sourceRepo
+			if destinationRegistryType == "google" {
+				name = strings.TrimPrefix(name, dockerRepoPrefix+"/")
+			}
+			if strings.HasPrefix(sourceRepo, artifactFilter) && (repo == "" || name == repo) {
 				destinationFilteredRepos = append(destinationFilteredRepos, sourceRepo)
 			}
 		}
 	} else {
 		destinationFilteredRepos = destinationRepos
 	}
-	log.Println("Found filtered destination repos: ", len(destinationFilteredRepos))
-	dockerCleanup := os.Getenv("DOCKER_CLEAN")
-	if dockerCleanup == "true" {
-		Clean(reposLimit, sourceFilteredRepos, destinationFilteredRepos, artifactFilter, destinationRegistry, creds, destinationRegistryType)
-		return
+	l.Info("Found filtered destination repos", "count", len(destinationFilteredRepos))
+	if job.Clean.Enabled {
+		return planClean(destinationFilteredRepos, job, p)
 	}
+	workers := pool.New(job.Concurrency, job.ConcurrencyPerHost)
+	hosts := []string{sourceRegistry, destinationRegistry}
 	for _, sourceRepo := range sourceFilteredRepos {
-		sourceTags, err := listTags(sourceRegistry, sourceRepo, creds.SourceUser, creds.SourcePassword)
+		sourceTags, err := listTags(sourceRegistry, sourceRepo, creds.SourceUser, creds.SourcePassword, job.Docker.PageSize)
 		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-				panic(err2)
-			}
-			panic(err)
+			workers.Wait()
+			return fmt.Errorf("listing tags of %s/%s: %w", sourceRegistry, sourceRepo, err)
 		}
 		var sourceTagsFiltered []string
 		if dockerTag != "" {
@@ -159,6 +192,9 @@ func Replicate(creds credentials.Creds, sourceRegistry string, destinationRegist
 		} else {
 			sourceTagsFiltered = sourceTags
 		}
+		if len(sourceTagsFiltered) == 0 {
+			continue
+		}
 		repoFound := false
 		for _, destinationRepo := range destinationFilteredRepos {
 			if destinationRegistryType == "google" {
@@ -166,11 +202,49 @@ func Replicate(creds credentials.Creds, sourceRegistry string, destinationRegist
 			}
 			if sourceRepo == destinationRepo {
 				repoFound = true
-				//log.Println("Found repo: " + sourceRepo)
+				l.Debug("Found repo at destination", "repo", sourceRepo)
 				break
 			}
 		}
+		destinationRepo := sourceRepo
+		if dockerRepoPrefix != "" {
+			destinationRepo = dockerRepoPrefix + "/" + sourceRepo
+		}
+		var destinationTags []string
+		if repoFound {
+			destinationTags, err = listTags(destinationRegistry, destinationRepo, creds.DestinationUser, creds.DestinationPassword, job.Docker.PageSize)
+			if err != nil {
+				workers.Wait()
+				return fmt.Errorf("listing tags of %s/%s: %w", destinationRegistry, destinationRepo, err)
+			}
+		} else {
+			l.Info("Destination repo not found", "repo", sourceRepo)
+		}
 		for _, sourceTag := range sourceTagsFiltered {
+			action := plan.Action{Job: job.Name, Name: sourceRepo + ":" + sourceTag, Repo: sourceRepo, Ref: sourceTag}
+			destinationTagFound := false
+			for _, destinationTag := range destinationTags {
+				if sourceTag == destinationTag {
+					destinationTagFound = true
+					l.Debug("Found repo tag at destination", "repo", sourceRepo, "tag", sourceTag)
+					break
+				}
+			}
+			if !destinationTagFound {
+				if repoFound {
+					l.Info("Repo tag not found at destination, replicating", "repo", sourceRepo, "tag", sourceTag)
+					action.Op, action.Reason = plan.OpCopy, "tag not found at destination"
+				} else {
+					action.Op, action.Reason = plan.OpCopy, "repo not found at destination"
+				}
+				p.Add(action)
+				continue
+			}
+			if tagPolicy == tagPolicyName {
+				action.Op, action.Reason = plan.OpSkip, "present at destination"
+				p.Add(action)
+				continue
+			}
 			image := ImageToReplicate{
 				SourceRegistry:      sourceRegistry,
 				SourceImage:         sourceRepo,
@@ -178,58 +252,73 @@ func Replicate(creds credentials.Creds, sourceRegistry string, destinationRegist
 				DestinationImage:    sourceRepo,
 				SourceTag:           sourceTag,
 				DestinationTag:      sourceTag,
+				Platforms:           job.Docker.Platforms,
+				Job:                 job.Name,
 			}
-			if !repoFound {
-				log.Println("Destination repo not found: " + sourceRepo)
-				err := doReplicateDocker(image, creds, destinationRegistryType, &repoFound, dockerRepoPrefix)
+			sourceRepo, sourceTag := sourceRepo, sourceTag
+			workers.Go(hosts, func() {
+				changed, reason, err := tagChanged(image, destinationRepo, creds, tagPolicy)
 				if err != nil {
-					err2 := slack.SendMessage(err.Error())
-					if err2 != nil {
-						log.Println(err)
-						panic(err2)
-					}
-					panic(err)
-				}
-				copiedArtifacts++
-			} else {
-				destinationTagFound := false
-				destinationRepo := sourceRepo
-				if dockerRepoPrefix != "" {
-					destinationRepo = dockerRepoPrefix + "/" + sourceRepo
-				}
-				destinationTags, err := listTags(destinationRegistry, destinationRepo, creds.DestinationUser, creds.DestinationPassword)
-				if err != nil {
-					err2 := slack.SendMessage(err.Error())
-					if err2 != nil {
-						log.Println(err)
-						panic(err2)
-					}
-					panic(err)
-				}
-				for _, destinationTag := range destinationTags {
-					if sourceTag == destinationTag {
-						destinationTagFound = true
-						//log.Println("Found repo tag: " + sourceRepo + ":" + sourceTag)
-						break
-					}
-				}
-				if destinationTagFound {
-					continue
+					l.Warn("Error comparing tag digests, ignoring", "repo", sourceRepo, "tag", sourceTag, "err", err)
+					action.Op, action.Reason = plan.OpError, "comparing digests: "+err.Error()
+				} else if changed {
+					action.Op, action.Reason = plan.OpOverwrite, reason
 				} else {
-					log.Println("Repo tag: " + sourceRepo + ":" + sourceTag + " not found at destination, replicating...")
-					err := doReplicateDocker(image, creds, destinationRegistryType, &repoFound, dockerRepoPrefix)
-					if err != nil {
-						err2 := slack.SendMessage(err.Error())
-						if err2 != nil {
-							log.Println(err)
-							panic(err2)
-						}
-						panic(err)
-					}
-					copiedArtifacts++
+					action.Op, action.Reason = plan.OpSkip, reason
 				}
+				p.Add(action)
+			})
+		}
+	}
+	workers.Wait()
+	return nil
+}
+
+// Apply runs the planned actions of the job, without comparing source and destination again.
+// The repos of aws destinations must be created first.
+func Apply(job config.Job, actions []plan.Action) (*result.Result, error) {
+	res := result.New()
+	defer res.Finish()
+	err := job.Validate()
+	if err != nil {
+		return res, err
+	}
+	creds := job.Creds()
+	l := logger.Job(job)
+	workers := pool.New(job.Concurrency, job.ConcurrencyPerHost)
+	hosts := []string{job.Source.Registry, job.Destination.Registry}
+	mounts := newBlobMounts()
+	for _, a := range actions {
+		switch a.Op {
+		case plan.OpCopy, plan.OpOverwrite:
+			image := ImageToReplicate{
+				SourceRegistry:      job.Source.Registry,
+				SourceImage:         a.Repo,
+				DestinationRegistry: job.Destination.Registry,
+				DestinationImage:    a.Repo,
+				SourceTag:           a.Ref,
+				DestinationTag:      a.Ref,
+				Platforms:           job.Docker.Platforms,
+				Job:                 job.Name,
+				mounts:              mounts,
+			}
+			workers.Go(hosts, func() {
+				doReplicateDocker(image, creds, job.Destination.Type, job.Docker.RepoPrefix, job.Docker.CopyMode, res)
+			})
+		case plan.OpDelete:
+			l.Info("Removing tag", "repo", a.Repo, "tag", a.Ref, "reason", a.Reason)
+			err := dockerRemoveTag(l, job.Destination.Registry, a.Repo, a.Ref, job.Destination.Type, creds.DestinationUser, creds.DestinationPassword, res)
+			if err != nil {
+				l.Error("Error removing tag", "repo", a.Repo, "tag", a.Ref, "err", err)
+				res.AddFailed(a.Name, "delete", err)
 			}
+		case plan.OpSkip:
+			res.AddSkipped(a.Name, a.Reason)
+		case plan.OpError:
+			res.AddFailed(a.Name, "compare", errors.New(a.Reason))
 		}
 	}
-	log.Printf("%d artifacts copied\n", copiedArtifacts)
+	workers.Wait()
+	l.Info("Artifacts copied", "count", len(res.Copied))
+	return res, nil
 }
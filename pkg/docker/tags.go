@@ -2,157 +2,105 @@ package docker
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/result"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
-func listTags(dockerRegistry string, image string, user string, pass string) ([]string, error) {
-	httpClient := &http.Client{}
-	url := "https://" + dockerRegistry + "/v2/" + image + "/tags/list?n=10000000"
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.SetBasicAuth(user, pass)
-	var resp *http.Response
-	var failed bool
-	backOffTime := backOffStart
-	for i := 1; i <= backOffSteps; i++ {
-		resp, err = httpClient.Do(req)
+func listTags(dockerRegistry string, image string, user string, pass string, n int) ([]string, error) {
+	defer metrics.ObserveOperation("list", time.Now())
+	client := newRegistryClient(dockerRegistry, user, pass)
+	var tags []string
+	err := client.getPages(client.url(image, "tags/list"), pageSize(n), func(body []byte) error {
+		type res struct {
+			Name string
+			Tags []string
+		}
+		var b res
+		err := json.Unmarshal(body, &b)
 		if err != nil {
-			failed = true
-			log.Print("error HTTP GET", url, "retry", string(i))
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			defer resp.Body.Close()
-			failed = false
-			break
+			logger.Error("Error decoding docker registry tags response", "repo", image, "err", err)
+			return err
 		}
-	}
-	if failed == true {
-		return nil, err
-	}
-	body, err := ioutil.ReadAll(resp.Body)
+		tags = append(tags, b.Tags...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	type res struct {
-		Name string
-		Tags []string
-	}
-	var b res
-	err = json.Unmarshal(body, &b)
-	if err != nil {
-		log.Println("docker registry response json unmarshall error")
-		return nil, err
-	}
-	return b.Tags, nil
+	return tags, nil
 }
 
-func dockerRemoveTag(registry string, image string, tag string, destinationRegistryType string, user string, pass string) error {
+// dockerRemoveTag removes the tag and its manifest, recording it as deleted or skipped in res
+func dockerRemoveTag(l *logger.Logger, registry string, image string, tag string, destinationRegistryType string, user string, pass string, res *result.Result) error {
+	defer metrics.ObserveOperation("delete", time.Now())
+	l = l.With("repo", image, "tag", tag)
 	if destinationRegistryType == "azure" {
 		digest, err := GetAzureDockerTagManifestDigest(registry, image, tag, user, pass)
 		if err != nil {
 			return err
 		}
-		log.Println("Removing tag:", registry+"/"+image+":"+tag)
-		url := "https://" + registry + "/acr/v1/" + image + "/_tags/" + tag
-		client := &http.Client{}
+		l.Debug("Removing tag reference")
+		url := httpclient.URL(registry) + "/acr/v1/" + image + "/_tags/" + tag
+		client := newAuthClient(registry, user, pass)
 		req, err := http.NewRequest("DELETE", url, nil)
 		if err != nil {
 			return err
 		}
-		req.SetBasicAuth(user, pass)
-		var resp *http.Response
-		var failed bool
-		backOffTime := backOffStart
-		for i := 1; i <= backOffSteps; i++ {
-			resp, err = client.Do(req)
-			defer resp.Body.Close()
-			if err != nil {
-				failed = true
-				log.Print("error HTTP GET", url, "retry", string(i))
-				if i != backOffSteps {
-					time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-				}
-				backOffTime *= i
-			} else {
-				failed = false
-				break
-			}
-		}
-		if failed == true {
+		resp, err := retry.HTTP(client, req)
+		if err != nil {
 			return err
 		}
+		defer resp.Body.Close()
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
 		if strings.Contains(string([]byte(body)), "error") || strings.Contains(string([]byte(body)), "Error") {
-			log.Println("Error removing tag", image+":"+tag)
-			log.Println(string([]byte(body)))
-			log.Println("Ignoring...")
-			SkippedTags++
+			l.Warn("Error removing tag, ignoring", "response", string(body))
+			res.AddSkipped(image+":"+tag, "tag removal failed: "+string(body))
 			return nil
 		}
 		if digest != "" {
-			log.Println("Removing", image+":"+tag, "digest:", digest)
-			urlTag := "https://" + registry + "/v2/" + image + "/manifests/" + digest
-			clientTag := &http.Client{}
+			l.Debug("Removing tag manifest", "digest", digest)
+			urlTag := httpclient.URL(registry) + "/v2/" + image + "/manifests/" + digest
+			clientTag := newAuthClient(registry, user, pass)
 			reqTag, err := http.NewRequest("DELETE", urlTag, nil)
 			if err != nil {
 				return err
 			}
-			reqTag.SetBasicAuth(user, pass)
-			var respTag *http.Response
-			var failed bool
-			backOffTime := backOffStart
-			for i := 1; i <= backOffSteps; i++ {
-				respTag, err = clientTag.Do(reqTag)
-				defer respTag.Body.Close()
-				if err != nil {
-					failed = true
-					log.Print("error HTTP DELETE", url, "retry", string(i))
-					if i != backOffSteps {
-						time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-					}
-					backOffTime *= i
-				} else {
-					failed = false
-					break
-				}
-			}
-			if failed == true {
+			respTag, err := retry.HTTP(clientTag, reqTag)
+			if err != nil {
 				return err
 			}
+			defer respTag.Body.Close()
 			bodyTag, err := ioutil.ReadAll(respTag.Body)
 			if err != nil {
 				return err
 			}
 			if strings.Contains(string([]byte(bodyTag)), "error") || strings.Contains(string([]byte(bodyTag)), "Error") {
-				log.Println("Error removing tag", image+":"+tag)
-				log.Println(string([]byte(bodyTag)))
-				log.Println("Ignoring...")
-				SkippedTags++
+				l.Warn("Error removing tag manifest, ignoring", "digest", digest, "response", string(bodyTag))
+				res.AddSkipped(image+":"+tag, "manifest removal failed: "+string(bodyTag))
 				return nil
 			}
 		} else {
-			log.Println("Tag", image+":"+tag, "have empty digest, skipping...")
-			SkippedTags++
+			l.Warn("Tag has an empty digest, skipping")
+			res.AddSkipped(image+":"+tag, "empty digest")
 			return nil
 		}
 	} else {
-		log.Println("Unknown destination registry type:", destinationRegistryType)
-		return errors.New("unknown destination registry type")
+		return fmt.Errorf("%w: tag removal not supported for destination registry type %q", config.ErrInvalid, destinationRegistryType)
 	}
-	log.Println("Removed tag:", registry+"/"+image+":"+tag)
-	RemovedTags++
+	l.Info("Removed tag")
+	res.AddDeleted(result.Item{Name: image + ":" + tag, Op: "delete"})
 	return nil
 }
@@ -2,31 +2,63 @@ package ecr
 
 import (
 	"encoding/base64"
-	"log"
+	"errors"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
 )
 
-func GetToken() (string, string, error) {
-	sess, err := session.NewSession(&aws.Config{})
+// Client calls the ECR API with the access keys of a job
+type Client struct {
+	svc *ecr.ECR
+}
+
+// NewClient returns a client using the access keys, or the default AWS credentials, e.g. the AWS_* env variables
+// or the instance role, if they are empty. The keys are never set in the environment, so jobs don't share them.
+func NewClient(accessKey string, secretKey string) (*Client, error) {
+	cfg := &aws.Config{HTTPClient: httpclient.Client()}
+	if accessKey != "" || secretKey != "" {
+		cfg.Credentials = credentials.NewStaticCredentials(accessKey, secretKey, "")
+	}
+	sess, err := session.NewSession(cfg)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	svc := ecr.New(sess)
-	input := &ecr.GetAuthorizationTokenInput{}
-	result, err := svc.GetAuthorizationToken(input)
+	return &Client{svc: ecr.New(sess)}, nil
+}
+
+// Token returns the user and password of the registry
+func (c *Client) Token() (string, string, error) {
+	result, err := c.svc.GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
 	if err != nil {
-		log.Println("svc.GetAuthorizationToken error")
 		return "", "", err
 	}
+	if len(result.AuthorizationData) == 0 {
+		return "", "", errors.New("no authorization data returned from ECR")
+	}
 	encodedToken := *result.AuthorizationData[0].AuthorizationToken
 	decodedToken, err := base64.StdEncoding.DecodeString(encodedToken)
 	if err != nil {
 		return "", "", err
 	}
-	tokenSplit := strings.Split(string(decodedToken), ":")
+	tokenSplit := strings.SplitN(string(decodedToken), ":", 2)
+	if len(tokenSplit) != 2 {
+		return "", "", errors.New("wrong authorization token returned from ECR")
+	}
 	return tokenSplit[0], tokenSplit[1], nil
 }
+
+// CreateRepository creates the repository, unless it exists already
+func (c *Client) CreateRepository(repo string) error {
+	_, err := c.svc.CreateRepository(&ecr.CreateRepositoryInput{RepositoryName: &repo})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == ecr.ErrCodeRepositoryAlreadyExistsException {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,247 @@
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// The sha256 of a file is kept in sha256sum format in a hidden sidecar next to it, .<name>.sha256sum,
+// which can't be mistaken for an artifact such as index.yaml.sha256
+const (
+	sidecarPrefix = "."
+	sidecarSuffix = ".sha256sum"
+)
+
+// tempPrefix is the prefix of the files being written, renamed once complete
+const tempPrefix = ".tmp-"
+
+// Storage stores binary artifacts in a local or NFS directory tree, with the same layout as the buckets
+type Storage struct {
+	Root string
+}
+
+// NewStorage returns the storage of the directory
+func NewStorage(root string) *Storage {
+	return &Storage{Root: root}
+}
+
+// path returns the file path of the key, which must stay inside the root
+func (s *Storage) path(key string) (string, error) {
+	root := filepath.Clean(s.Root)
+	path := filepath.Join(root, filepath.FromSlash(key))
+	if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", errors.New("key " + key + " is outside of " + s.Root)
+	}
+	return path, nil
+}
+
+// List returns the files under the prefix with the sha256 of their sidecar, skipping the sidecars and temp files
+func (s *Storage) List(prefix string) ([]storage.Object, error) {
+	dir := ""
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		dir = prefix[:i]
+	}
+	start, err := s.path(dir)
+	if err != nil {
+		return nil, err
+	}
+	var objects []storage.Object
+	err = filepath.Walk(start, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == start {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || isSidecar(info.Name()) || strings.HasPrefix(info.Name(), tempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		o := storage.Object{Key: key, Size: info.Size(), Modified: info.ModTime()}
+		o.SHA256, err = readSidecar(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		objects = append(objects, o)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Stat returns the file with the sha256 of its sidecar, empty if there is no sidecar
+func (s *Storage) Stat(key string) (storage.Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return storage.Object{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Object{}, err
+	}
+	o := storage.Object{Key: key, Size: info.Size(), Modified: info.ModTime()}
+	o.SHA256, err = readSidecar(path)
+	if err != nil && !os.IsNotExist(err) {
+		return storage.Object{}, err
+	}
+	return o, nil
+}
+
+// SHA256 returns the checksum of the sidecar written by Put, or computes it for files copied without one
+func (s *Storage) SHA256(key string) (string, error) {
+	o, err := s.Stat(key)
+	if err != nil {
+		return "", err
+	}
+	if o.SHA256 != "" {
+		return o.SHA256, nil
+	}
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	return sha256.ComputeFileSHA256(path)
+}
+
+// Get copies the file to the path
+func (s *Storage) Get(key string, path string) error {
+	source, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(source)
+	if os.IsNotExist(err) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Put copies the file to a temp file next to the destination and renames it, then writes the sha256 sidecar the same way,
+// so that readers of the tree, e.g. a sync to the air-gapped site, never see a partial file
+func (s *Storage) Put(key string, path string) error {
+	destination, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	fileSHA256, err := sha256.ComputeFileSHA256(path)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Copying", "destination", s.Root, "file", key, "sha256", fileSHA256)
+	err = os.MkdirAll(filepath.Dir(destination), 0755)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	err = writeAtomic(destination, in)
+	if err != nil {
+		return err
+	}
+	sidecar := fileSHA256 + "  " + filepath.Base(destination) + "\n"
+	return writeAtomic(sidecarPath(destination), strings.NewReader(sidecar))
+}
+
+// Delete removes the file and its sidecar, an already removed file is not an error
+func (s *Storage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	logger.Info("Removing", "destination", s.Root, "file", key)
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(sidecarPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeAtomic writes r to a temp file in the directory of path, syncs it and renames it to path
+func writeAtomic(path string, r io.Reader) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), tempPrefix+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tempFile, r)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if err == nil {
+		err = tempFile.Chmod(0644)
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return nil
+}
+
+// sidecarPath returns the path of the sidecar of the file
+func sidecarPath(path string) string {
+	return filepath.Join(filepath.Dir(path), sidecarPrefix+filepath.Base(path)+sidecarSuffix)
+}
+
+// isSidecar tells whether the file name is the one of a sidecar
+func isSidecar(name string) bool {
+	return len(name) > len(sidecarPrefix+sidecarSuffix) && strings.HasPrefix(name, sidecarPrefix) && strings.HasSuffix(name, sidecarSuffix)
+}
+
+// readSidecar returns the sha256 of the sidecar of the file
+func readSidecar(path string) (string, error) {
+	body, err := ioutil.ReadFile(sidecarPath(path))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
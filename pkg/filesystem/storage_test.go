@@ -0,0 +1,72 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// TestSidecar checks that an artifact named like a checksum, e.g. index.yaml.sha256, is neither hidden
+// nor overwritten by the sidecar of the file it is the checksum of
+func TestSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesystem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewStorage(filepath.Join(dir, "root"))
+	files := map[string]string{"charts/index.yaml": "entries: {}\n", "charts/index.yaml.sha256": "abc\n"}
+	for key, data := range files {
+		path := filepath.Join(dir, filepath.Base(key))
+		err := ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Put(key, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := s.List("charts/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := storage.Index(objects)
+	if len(objects) != len(files) {
+		t.Errorf("listed %v, expected %d files", objects, len(files))
+	}
+	for key, data := range files {
+		o, ok := index[key]
+		if !ok {
+			t.Errorf("%s not listed", key)
+			continue
+		}
+		expected, _ := sha256.ComputeFileSHA256(filepath.Join(dir, filepath.Base(key)))
+		if o.SHA256 != expected {
+			t.Errorf("sha256 of %s is %q, expected %q", key, o.SHA256, expected)
+		}
+		body, err := ioutil.ReadFile(filepath.Join(s.Root, filepath.FromSlash(key)))
+		if err != nil || string(body) != data {
+			t.Errorf("%s holds %q, %v, expected %q", key, body, err, data)
+		}
+	}
+	err = s.Delete("charts/index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err = s.List("charts/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "charts/index.yaml.sha256" {
+		t.Errorf("listed %v after removing charts/index.yaml, expected charts/index.yaml.sha256", objects)
+	}
+	_, err = os.Stat(filepath.Join(s.Root, "charts", ".index.yaml.sha256sum"))
+	if !os.IsNotExist(err) {
+		t.Errorf("sidecar of charts/index.yaml not removed: %v", err)
+	}
+}
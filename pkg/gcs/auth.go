@@ -0,0 +1,158 @@
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+)
+
+const (
+	scope            = "https://www.googleapis.com/auth/devstorage.read_write"
+	defaultTokenURI  = "https://oauth2.googleapis.com/token"
+	metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+type token struct {
+	value   string
+	expires time.Time
+}
+
+// tokens caches the access tokens by credential, as a storage is created for every uploaded file
+var tokens = struct {
+	sync.Mutex
+	m map[string]token
+}{m: make(map[string]token)}
+
+// serviceAccountKey is the JSON key file of a service account
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// accessToken returns an OAuth2 access token for the credential, which is either a service account JSON key,
+// an access token, e.g. from gcloud auth print-access-token, or empty to ask the GCE metadata server
+func accessToken(credential string) (string, error) {
+	credential = strings.TrimSpace(credential)
+	if credential != "" && !strings.HasPrefix(credential, "{") {
+		return credential, nil
+	}
+	tokens.Lock()
+	defer tokens.Unlock()
+	t, ok := tokens.m[credential]
+	if ok && time.Now().Add(time.Minute).Before(t.expires) {
+		return t.value, nil
+	}
+	var err error
+	if credential == "" {
+		t, err = metadataToken()
+	} else {
+		t, err = serviceAccountToken(credential)
+	}
+	if err != nil {
+		return "", err
+	}
+	tokens.m[credential] = t
+	return t.value, nil
+}
+
+func metadataToken() (token, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return token{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return requestToken(req)
+}
+
+// serviceAccountToken exchanges a JWT signed with the service account key for an access token
+func serviceAccountToken(credential string) (token, error) {
+	var key serviceAccountKey
+	err := json.Unmarshal([]byte(credential), &key)
+	if err != nil {
+		return token{}, errors.New("parsing service account key: " + err.Error())
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = defaultTokenURI
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return token{}, errors.New("no PEM private key in service account key of " + key.ClientEmail)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return token{}, err
+		}
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return token{}, errors.New("private key of " + key.ClientEmail + " is not an RSA key")
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": scope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return token{}, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	req, err := http.NewRequest(http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return requestToken(req)
+}
+
+func requestToken(req *http.Request) (token, error) {
+	client := &http.Client{Transport: httpclient.Transport, Timeout: 30 * time.Second}
+	resp, err := retry.HTTP(client, req)
+	if err != nil {
+		return token{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return token{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return token{}, retry.NewHTTPError(resp.StatusCode, "getting access token from "+req.URL.Host+" returned "+resp.Status+": "+string(body))
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return token{}, err
+	}
+	return token{value: result.AccessToken, expires: time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)}, nil
+}
@@ -0,0 +1,230 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+const defaultEndpoint = "https://storage.googleapis.com"
+
+// Storage stores binary artifacts in a Google Cloud Storage bucket, using the JSON API
+type Storage struct {
+	Bucket string
+	// Endpoint is the API root, e.g. http://localhost:4443 for a fake GCS server
+	Endpoint string
+	// credential is a service account JSON key or an access token, the metadata server is used when empty
+	credential string
+}
+
+// NewStorage returns the storage of the bucket. The destination password is a service account JSON key, e.g.
+// file:/path/key.json, or an access token. Without it, the token of the GCE metadata server is used,
+// unless the endpoint is set, which is then expected to be a fake server without auth.
+func NewStorage(bucket string, creds credentials.Creds, endpoint string) *Storage {
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	return &Storage{Bucket: bucket, Endpoint: strings.TrimSuffix(endpoint, "/"), credential: creds.DestinationPassword}
+}
+
+func (s *Storage) objectURL(key string) string {
+	return s.Endpoint + "/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o/" + url.PathEscape(key)
+}
+
+// authorize sets the bearer token of the request, except for fake servers without credential
+func (s *Storage) authorize(req *http.Request) error {
+	if s.credential == "" && s.Endpoint != defaultEndpoint {
+		return nil
+	}
+	t, err := accessToken(s.credential)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t)
+	return nil
+}
+
+// do sends the request built by newRequest, retrying network errors, 429 and 5xx responses.
+// The request is built again for every attempt, so that its body can be read again.
+func (s *Storage) do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	// 308 means resume incomplete for uploads, not a redirect to follow
+	client := &http.Client{Transport: httpclient.Transport, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	var resp *http.Response
+	err := retry.Do(context.Background(), "calling gcs bucket "+s.Bucket, func() error {
+		req, err := newRequest()
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		err = s.authorize(req)
+		if err != nil {
+			return err
+		}
+		r, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		if retry.RetryableStatus(r.StatusCode) {
+			return retry.StatusError(r)
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *Storage) get(url string) (*http.Response, error) {
+	return s.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, url, nil)
+	})
+}
+
+// apiError returns the error of an unexpected response, with the message of the JSON API
+func apiError(action string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return retry.NewHTTPError(resp.StatusCode, action+" returned "+resp.Status+": "+strings.TrimSpace(string(body)))
+}
+
+// object is the object resource of the JSON API
+type object struct {
+	Name     string            `json:"name"`
+	Size     string            `json:"size"`
+	Updated  time.Time         `json:"updated"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func (o object) storageObject() storage.Object {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return storage.Object{Key: o.Name, Size: size, Modified: o.Updated, SHA256: o.Metadata["sha256"]}
+}
+
+// List returns the objects under the prefix, with the sha256 stored by Put, following the page tokens
+func (s *Storage) List(prefix string) ([]storage.Object, error) {
+	var objects []storage.Object
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", prefix)
+		query.Set("fields", "items(name,size,updated,metadata),nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		resp, err := s.get(s.Endpoint + "/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o?" + query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = apiError("listing "+s.Bucket+"/"+prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page struct {
+			Items         []object `json:"items"`
+			NextPageToken string   `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Items {
+			objects = append(objects, o.storageObject())
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// Stat returns the object with the sha256 stored in its metadata by Put
+func (s *Storage) Stat(key string) (storage.Object, error) {
+	resp, err := s.get(s.objectURL(key))
+	if err != nil {
+		return storage.Object{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return storage.Object{}, storage.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return storage.Object{}, apiError("getting "+s.Bucket+"/"+key, resp)
+	}
+	var o object
+	err = json.NewDecoder(resp.Body).Decode(&o)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	return o.storageObject(), nil
+}
+
+// SHA256 returns the checksum stored in the object metadata by Put
+func (s *Storage) SHA256(key string) (string, error) {
+	o, err := s.Stat(key)
+	if err != nil {
+		return "", err
+	}
+	if o.SHA256 == "" {
+		return "", fmt.Errorf("%w in metadata of %s/%s", storage.ErrNoChecksum, s.Bucket, key)
+	}
+	return o.SHA256, nil
+}
+
+// Get downloads the object to the file path
+func (s *Storage) Get(key string, path string) error {
+	resp, err := s.get(s.objectURL(key) + "?alt=media")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return storage.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return apiError("downloading "+s.Bucket+"/"+key, resp)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Delete removes the object, an already removed object is not an error
+func (s *Storage) Delete(key string) error {
+	logger.Info("Removing", "destination", s.Bucket, "file", key)
+	resp, err := s.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodDelete, s.objectURL(key), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return apiError("removing "+s.Bucket+"/"+key, resp)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// fakeGCS is an in-memory bucket serving the JSON API calls of Storage
+type fakeGCS struct {
+	sync.Mutex
+	objects map[string]object
+	data    map[string][]byte
+	// uploads holds the bytes received by upload session
+	uploads map[string][]byte
+	// persist limits the bytes persisted from each chunk, to make the client resume
+	persist int
+	// stall makes the server persist nothing
+	stall    bool
+	requests int
+}
+
+func newFakeGCS() *fakeGCS {
+	return &fakeGCS{objects: make(map[string]object), data: make(map[string][]byte), uploads: make(map[string][]byte)}
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests++
+	const objects = "/storage/v1/b/bucket/o"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == objects:
+		f.list(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, objects+"/"):
+		o, ok := f.objects[strings.TrimPrefix(r.URL.Path, objects+"/")]
+		if !ok {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			w.Write(f.data[o.Name])
+			return
+		}
+		json.NewEncoder(w).Encode(o)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, objects+"/"):
+		key := strings.TrimPrefix(r.URL.Path, objects+"/")
+		if _, ok := f.objects[key]; !ok {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		delete(f.data, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+		var o object
+		json.NewDecoder(r.Body).Decode(&o)
+		o.Size = r.Header.Get("X-Upload-Content-Length")
+		session := "/session/" + strconv.Itoa(len(f.uploads))
+		f.uploads[session] = nil
+		f.objects[session] = o
+		w.Header().Set("Location", "http://"+r.Host+session)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/session/"):
+		f.upload(w, r)
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.String(), http.StatusBadRequest)
+	}
+}
+
+func (f *fakeGCS) list(w http.ResponseWriter, r *http.Request) {
+	var page struct {
+		Items         []object `json:"items"`
+		NextPageToken string   `json:"nextPageToken,omitempty"`
+	}
+	// one object by page, to follow the page tokens
+	for _, o := range f.sorted(r.URL.Query().Get("prefix")) {
+		if o.Name > r.URL.Query().Get("pageToken") {
+			page.Items = []object{o}
+			page.NextPageToken = o.Name
+			break
+		}
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func (f *fakeGCS) sorted(prefix string) []object {
+	var list []object
+	for name, o := range f.objects {
+		if strings.HasPrefix(name, prefix) && !strings.HasPrefix(name, "/session/") {
+			list = append(list, o)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// upload appends the chunk of the Content-Range at the persisted offset, keeping only persist bytes of it
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Path
+	received := f.uploads[session]
+	body, _ := ioutil.ReadAll(r.Body)
+	contentRange := r.Header.Get("Content-Range")
+	total := contentRange[strings.LastIndexByte(contentRange, '/')+1:]
+	if !strings.HasPrefix(contentRange, "bytes */") {
+		start, _ := strconv.Atoi(contentRange[len("bytes "):strings.IndexByte(contentRange, '-')])
+		if start != len(received) {
+			http.Error(w, "chunk at "+strconv.Itoa(start)+", expected "+strconv.Itoa(len(received)), http.StatusBadRequest)
+			return
+		}
+		if f.stall {
+			body = nil
+		}
+		if f.persist > 0 && len(body) > f.persist {
+			body = body[:f.persist]
+		}
+		received = append(received, body...)
+		f.uploads[session] = received
+	}
+	if strconv.Itoa(len(received)) != total {
+		if len(received) > 0 {
+			w.Header().Set("Range", "bytes=0-"+strconv.Itoa(len(received)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	o := f.objects[session]
+	delete(f.objects, session)
+	f.objects[o.Name] = o
+	f.data[o.Name] = received
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeGCS) add(name string, data string, sha string) {
+	f.objects[name] = object{Name: name, Size: strconv.Itoa(len(data)), Metadata: map[string]string{"sha256": sha}}
+	f.data[name] = []byte(data)
+}
+
+// newTestStorage returns a storage of a fake bucket, and the server to close
+func newTestStorage() (*Storage, *fakeGCS, *httptest.Server) {
+	f := newFakeGCS()
+	server := httptest.NewServer(f)
+	return &Storage{Bucket: "bucket", Endpoint: server.URL}, f, server
+}
+
+// writeFile writes the data to a temporary file, removed with its directory
+func writeFile(t *testing.T, data []byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "gcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestList(t *testing.T) {
+	s, f, server := newTestStorage()
+	defer server.Close()
+	f.add("charts/a-1.0.0.tgz", "a", "aaa")
+	f.add("charts/b-1.0.0.tgz", "bb", "bbb")
+	f.add("other/c", "c", "ccc")
+	objects, err := s.List("charts/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("listed %d objects, expected 2: %v", len(objects), objects)
+	}
+	index := storage.Index(objects)
+	if o := index["charts/b-1.0.0.tgz"]; o.Size != 2 || o.SHA256 != "bbb" {
+		t.Errorf("wrong object %+v", o)
+	}
+	if _, ok := index["charts/a-1.0.0.tgz"]; !ok {
+		t.Errorf("charts/a-1.0.0.tgz not listed")
+	}
+}
+
+func TestStat(t *testing.T) {
+	s, f, server := newTestStorage()
+	defer server.Close()
+	f.add("dir/file", "data", "abc")
+	o, err := s.Stat("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Key != "dir/file" || o.Size != 4 || o.SHA256 != "abc" {
+		t.Errorf("wrong object %+v", o)
+	}
+	_, err = s.Stat("missing")
+	if err != storage.ErrNotFound {
+		t.Errorf("Stat of a missing object returned %v, expected ErrNotFound", err)
+	}
+}
+
+func TestPut(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		size    int
+		persist int
+		// requests is the session start and the chunks sent
+		requests int
+	}{
+		{"empty", 0, 0, 2},
+		{"one chunk", 1000, 0, 2},
+		{"two chunks", chunkSize + 1000, 0, 3},
+		{"resumed", 3000, 1000, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, f, server := newTestStorage()
+			defer server.Close()
+			f.persist = tc.persist
+			data := bytes.Repeat([]byte("0123456789"), tc.size/10+1)[:tc.size]
+			path, remove := writeFile(t, data)
+			defer remove()
+			err := s.Put("dir/file", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(f.data["dir/file"], data) {
+				t.Errorf("uploaded %d bytes, expected %d", len(f.data["dir/file"]), len(data))
+			}
+			fileSHA256, _ := sha256.ComputeFileSHA256(path)
+			if sha := f.objects["dir/file"].Metadata["sha256"]; sha != fileSHA256 {
+				t.Errorf("stored sha256 %q, expected %q", sha, fileSHA256)
+			}
+			if f.requests != tc.requests {
+				t.Errorf("sent %d requests, expected %d", f.requests, tc.requests)
+			}
+		})
+	}
+}
+
+func TestPutStalled(t *testing.T) {
+	s, f, server := newTestStorage()
+	defer server.Close()
+	f.stall = true
+	path, remove := writeFile(t, []byte("data"))
+	defer remove()
+	err := s.Put("dir/file", path)
+	if err == nil {
+		t.Fatal("Put succeeded without anything persisted")
+	}
+	// the session start and maxStalls chunks
+	if f.requests != 1+maxStalls {
+		t.Errorf("sent %d requests, expected %d", f.requests, 1+maxStalls)
+	}
+	if _, ok := f.data["dir/file"]; ok {
+		t.Errorf("object created")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s, f, server := newTestStorage()
+	defer server.Close()
+	f.add("dir/file", "data", "abc")
+	err := s.Delete("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects["dir/file"]; ok {
+		t.Errorf("object not removed")
+	}
+	err = s.Delete("dir/file")
+	if err != nil {
+		t.Errorf("removing a removed object returned %v", err)
+	}
+}
+
+func TestPersisted(t *testing.T) {
+	for header, expected := range map[string]int64{"": 0, "bytes=0-0": 1, "bytes=0-1023": 1024} {
+		offset, err := persisted(header)
+		if err != nil || offset != expected {
+			t.Errorf("persisted(%q) = %d, %v, expected %d", header, offset, err, expected)
+		}
+	}
+	_, err := persisted("bytes=0-x")
+	if err == nil {
+		t.Errorf("persisted of a wrong header succeeded")
+	}
+}
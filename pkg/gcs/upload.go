@@ -0,0 +1,151 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+)
+
+// chunkSize of resumable uploads, a multiple of 256 KiB as required by the API
+const chunkSize = 8 * 1024 * 1024
+
+// maxStalls is how many chunks in a row may be sent without the persisted offset growing
+const maxStalls = 3
+
+// Put uploads the file with a resumable upload, storing its sha256 in the object metadata
+func (s *Storage) Put(key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSHA256, err := sha256.ComputeFileSHA256(path)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Uploading", "destination", s.Bucket, "file", key, "sha256", fileSHA256)
+	session, err := s.startUpload(key, info.Size(), fileSHA256)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	// every chunk is sent at most maxStalls times, then the upload fails
+	maxRequests := (size/chunkSize+1)*maxStalls + 1
+	var offset int64
+	stalls := 0
+	for requests := int64(0); ; requests++ {
+		if requests >= maxRequests {
+			return errors.New("uploading " + s.Bucket + "/" + key + ": gave up after " + strconv.FormatInt(requests, 10) + " requests at offset " + strconv.FormatInt(offset, 10))
+		}
+		n := size - offset
+		if n > chunkSize {
+			n = chunkSize
+		}
+		contentRange := "bytes */" + strconv.FormatInt(size, 10)
+		if n > 0 {
+			contentRange = "bytes " + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(offset+n-1, 10) + "/" + strconv.FormatInt(size, 10)
+		}
+		start := offset
+		resp, err := s.do(func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPut, session, io.NewSectionReader(f, start, n))
+			if err != nil {
+				return nil, err
+			}
+			req.ContentLength = n
+			req.Header.Set("Content-Range", contentRange)
+			return req, nil
+		})
+		if err != nil {
+			return err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated:
+			resp.Body.Close()
+			return nil
+		case http.StatusPermanentRedirect:
+			// 308 Resume Incomplete, Range is what the server persisted so far
+			offset, err = persisted(resp.Header.Get("Range"))
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			if offset <= start {
+				stalls++
+			} else {
+				stalls = 0
+			}
+			if stalls >= maxStalls {
+				return errors.New("uploading " + s.Bucket + "/" + key + ": server persisted nothing past offset " + strconv.FormatInt(offset, 10) + " after " + strconv.Itoa(stalls) + " attempts")
+			}
+			if offset > size {
+				return errors.New("uploading " + s.Bucket + "/" + key + ": server persisted " + strconv.FormatInt(offset, 10) + " bytes of " + strconv.FormatInt(size, 10))
+			}
+		default:
+			err = apiError("uploading "+s.Bucket+"/"+key, resp)
+			resp.Body.Close()
+			return err
+		}
+	}
+}
+
+// startUpload creates the resumable upload session of the object with its metadata and returns the session URL
+func (s *Storage) startUpload(key string, size int64, fileSHA256 string) (string, error) {
+	metadata, err := json.Marshal(map[string]interface{}{
+		"name":     key,
+		"metadata": map[string]string{"sha256": fileSHA256},
+	})
+	if err != nil {
+		return "", err
+	}
+	uploadURL := s.Endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o?uploadType=resumable&name=" + url.QueryEscape(key)
+	resp, err := s.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, uploadURL, bytes.NewReader(metadata))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", apiError("starting upload of "+s.Bucket+"/"+key, resp)
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", errors.New("no upload session returned for " + s.Bucket + "/" + key)
+	}
+	return session, nil
+}
+
+// persisted returns the offset following the Range header of a 308 response, e.g. bytes=0-1023, 0 without the header
+func persisted(header string) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	i := strings.LastIndexByte(header, '-')
+	if i < 0 {
+		return 0, errors.New("wrong Range header in upload response: " + header)
+	}
+	last, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return 0, errors.New("wrong Range header in upload response: " + header)
+	}
+	return last + 1, nil
+}
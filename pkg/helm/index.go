@@ -1,18 +1,21 @@
 package helm
 
 import (
+	"fmt"
 	"io/ioutil"
-	"log"
 	"strings"
 
 	"github.com/loqutus/artifactory-replication/pkg/artifactory"
-	"github.com/loqutus/artifactory-replication/pkg/s3"
-	"github.com/loqutus/artifactory-replication/pkg/slack"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
 	"k8s.io/helm/pkg/repo"
 )
 
-func RegenerateIndexYaml(artifactsList []string, artifactsListProd []string, sourceRepoUrl string, destinationRepoUrl string, sourceRepo string, prodRepo string, helmCdnDomain string) error {
-	log.Println("Regenerating index.yamls")
+// RegenerateIndexYaml merges the source and prod index.yaml of the helm directories of the copied artifacts,
+// and uploads it to the destination storage of the artifact repo returned by storageFor
+func RegenerateIndexYaml(artifactsList []string, artifactsListProd []string, sourceRepoUrl string, storageFor func(repo string) (storage.Storage, error), sourceRepo string, prodRepo string, helmCdnDomain string) error {
+	logger.Info("Regenerating index.yamls")
 	files := make(map[string]string)
 	replicatedArtifacts := append(artifactsList, artifactsListProd...)
 	for _, fileName := range replicatedArtifacts {
@@ -24,72 +27,51 @@ func RegenerateIndexYaml(artifactsList []string, artifactsListProd []string, sou
 		}
 	}
 	if len(files) == 0 {
-		log.Println("No helm charts was copied, will not regenerate index.yamls")
+		logger.Info("No helm charts were copied, not regenerating index.yamls")
 		return nil
 	}
 	for filePrefix, fileRepo := range files {
-		sourceFileLocalPath, err := artifactory.Download("https://"+sourceRepoUrl+"/artifactory/"+fileRepo+"/"+filePrefix+"/index.yaml", helmCdnDomain)
+		sourceFileLocalPath, err := artifactory.Download(httpclient.URL(sourceRepoUrl)+"/artifactory/"+fileRepo+"/"+filePrefix+"/index.yaml", helmCdnDomain)
 		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-			}
-			return err
+			return fmt.Errorf("downloading %s/%s/index.yaml: %w", fileRepo, filePrefix, err)
 		}
 		var sourceFileLocalPath2 string
 		if fileRepo == sourceRepo {
-			sourceFileLocalPath2, err = artifactory.Download("https://"+sourceRepoUrl+"/artifactory/"+prodRepo+"/"+filePrefix+"/index.yaml", helmCdnDomain)
+			sourceFileLocalPath2, err = artifactory.Download(httpclient.URL(sourceRepoUrl)+"/artifactory/"+prodRepo+"/"+filePrefix+"/index.yaml", helmCdnDomain)
 			if err != nil {
-				err2 := slack.SendMessage(err.Error())
-				if err2 != nil {
-					log.Println(err)
-				}
-				return err
+				return fmt.Errorf("downloading %s/%s/index.yaml: %w", prodRepo, filePrefix, err)
 			}
 		} else if fileRepo == prodRepo {
-			sourceFileLocalPath2, err = artifactory.Download("https://"+sourceRepoUrl+"/artifactory/"+sourceRepo+"/"+filePrefix+"/index.yaml", helmCdnDomain)
+			sourceFileLocalPath2, err = artifactory.Download(httpclient.URL(sourceRepoUrl)+"/artifactory/"+sourceRepo+"/"+filePrefix+"/index.yaml", helmCdnDomain)
 			if err != nil {
-				err2 := slack.SendMessage(err.Error())
-				if err2 != nil {
-					log.Println(err)
-				}
-				return err
+				return fmt.Errorf("downloading %s/%s/index.yaml: %w", sourceRepo, filePrefix, err)
 			}
 		}
 		sourceIndexFile, err := repo.LoadIndexFile(sourceFileLocalPath)
 		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-			}
-			return err
+			return fmt.Errorf("loading %s/%s/index.yaml: %w", fileRepo, filePrefix, err)
 		}
 		sourceIndexFile2, err := repo.LoadIndexFile(sourceFileLocalPath2)
 		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-			}
-			return err
+			return fmt.Errorf("loading index.yaml of %s: %w", filePrefix, err)
 		}
 		sourceIndexFile.Merge(sourceIndexFile2)
 		tempFile, err := ioutil.TempFile("", "index-yaml")
 		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-			}
 			return err
 		}
-		sourceIndexFile.WriteFile(tempFile.Name(), 0644)
-		err = s3.Upload(destinationRepoUrl, filePrefix+"/index.yaml", tempFile.Name())
+		err = sourceIndexFile.WriteFile(tempFile.Name(), 0644)
+		if err != nil {
+			return err
+		}
+		st, err := storageFor(fileRepo)
 		if err != nil {
-			err2 := slack.SendMessage(err.Error())
-			if err2 != nil {
-				log.Println(err)
-			}
 			return err
 		}
+		err = st.Put(filePrefix+"/index.yaml", tempFile.Name())
+		if err != nil {
+			return fmt.Errorf("uploading %s/index.yaml: %w", filePrefix, err)
+		}
 	}
 	return nil
 }
@@ -1,15 +1,18 @@
 package helm
 
 import (
-	"log"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/loqutus/artifactory-replication/pkg/s3"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
 	"k8s.io/helm/pkg/repo"
 )
 
-func Reindex(filesList []string, registry string, allFiles []string, helmCdnDomain string) error {
+// Reindex regenerates the index.yaml of the helm directories of the files in filesList, from the charts left in the storage
+func Reindex(st storage.Storage, filesList []string, helmCdnDomain string) error {
 	var filePrefixes = make(map[string]bool)
 	for _, file := range filesList {
 		if strings.Contains(file, "/helm/") {
@@ -18,9 +21,9 @@ func Reindex(filesList []string, registry string, allFiles []string, helmCdnDoma
 			filePrefixes[filePrefix] = true
 		}
 	}
-	for prefix, _ := range filePrefixes {
-		log.Println("Reindexing", prefix)
-		dir, err := s3.DownloadAllFiles(registry, prefix, allFiles)
+	for prefix := range filePrefixes {
+		logger.Info("Reindexing", "repo", prefix)
+		dir, err := downloadDir(st, prefix)
 		if err != nil {
 			return err
 		}
@@ -34,12 +37,37 @@ func Reindex(filesList []string, registry string, allFiles []string, helmCdnDoma
 		if err != nil {
 			return err
 		}
-		log.Println("Written index file", tempFileName)
-		log.Println("Uploading", prefix+"/index.yaml")
-		err = s3.Upload(registry, prefix+"/index.yaml", tempFileName)
+		logger.Debug("Uploading index file", "file", prefix+"/index.yaml", "path", tempFileName)
+		err = st.Put(prefix+"/index.yaml", tempFileName)
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// downloadDir downloads the files directly in the storage directory to a new temp dir
+func downloadDir(st storage.Storage, prefix string) (string, error) {
+	logger.Debug("Downloading all files", "repo", prefix)
+	objects, err := st.List(prefix + "/")
+	if err != nil {
+		return "", err
+	}
+	tempDir, err := ioutil.TempDir("", "helm-reindex")
+	if err != nil {
+		return "", err
+	}
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Key, prefix+"/")
+		if strings.Contains(name, "/") || name == "index.yaml" {
+			continue
+		}
+		logger.Debug("Downloading", "file", o.Key)
+		err := st.Get(o.Key, filepath.Join(tempDir, name))
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return "", err
+		}
+	}
+	return tempDir, nil
+}
@@ -0,0 +1,270 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultReadTimeout    = 5 * time.Minute
+	defaultMaxIdleConns   = 16
+	idleConnTimeout       = 90 * time.Second
+)
+
+// endpoint is a host with its scheme and the transport built from its settings
+type endpoint struct {
+	scheme    string
+	settings  config.HTTP
+	transport http.RoundTripper
+}
+
+// endpoints holds the registered hosts, the other ones use defaultEndpoint
+var endpoints = struct {
+	sync.Mutex
+	m map[string]*endpoint
+}{m: make(map[string]*endpoint)}
+
+var defaultEndpoint = struct {
+	once sync.Once
+	e    *endpoint
+}{}
+
+// dialer dials the connections of every transport, the tunnel replaces it to go through the jump host
+var dialer = struct {
+	sync.RWMutex
+	dial func(ctx context.Context, network string, address string) (net.Conn, error)
+}{dial: (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext}
+
+// Transport sends every request with the transport of its host, which pools its keep-alive connections
+var Transport http.RoundTripper = router{}
+
+var client = &http.Client{Transport: Transport}
+
+// Client returns the shared client sending requests with Transport
+func Client() *http.Client {
+	return client
+}
+
+// SetDialContext replaces the function every transport dials connections with
+func SetDialContext(dial func(ctx context.Context, network string, address string) (net.Conn, error)) {
+	dialer.Lock()
+	defer dialer.Unlock()
+	dialer.dial = dial
+}
+
+// Configure registers the scheme and HTTP settings of the registry hosts of every job.
+// A registry without settings uses the ones registered for its host by another job, or the defaults.
+func Configure(c *config.Config) error {
+	for _, j := range c.Jobs {
+		registries := []config.Registry{j.Source, j.Clean.Prod}
+		if j.Type == "docker" || j.Destination.Type == "artifactory" {
+			registries = append(registries, j.Destination)
+		}
+		for _, r := range registries {
+			err := Register(host(r.Registry), r)
+			if err != nil {
+				return fmt.Errorf("job %s: %w", j.Name, err)
+			}
+		}
+		endpoint := ""
+		if j.Destination.Type == "gcs" {
+			endpoint = j.Binary.GCSEndpoint
+		} else if j.Destination.Type == "azblob" {
+			endpoint = j.Binary.AzblobEndpoint
+		}
+		if endpoint != "" {
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return fmt.Errorf("%w: job %s: wrong endpoint %q: %v", config.ErrInvalid, j.Name, endpoint, err)
+			}
+			r := j.Destination
+			r.Scheme = u.Scheme
+			err = Register(u.Host, r)
+			if err != nil {
+				return fmt.Errorf("job %s: %w", j.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Register sets the scheme and HTTP settings of the host, loading its certificates.
+// Registering different settings for the same host is an error.
+func Register(host string, r config.Registry) error {
+	if host == "" || (r.Scheme == "" && r.HTTP == config.HTTP{}) {
+		return nil
+	}
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	endpoints.Lock()
+	defer endpoints.Unlock()
+	if e, ok := endpoints.m[host]; ok {
+		if e.scheme != scheme || e.settings != r.HTTP {
+			return fmt.Errorf("%w: different scheme or http settings for host %s", config.ErrInvalid, host)
+		}
+		return nil
+	}
+	transport, err := newTransport(r.HTTP)
+	if err != nil {
+		return fmt.Errorf("%w: host %s: %v", config.ErrInvalid, host, err)
+	}
+	endpoints.m[host] = &endpoint{scheme: scheme, settings: r.HTTP, transport: transport}
+	return nil
+}
+
+// URL returns the root URL of the host with its scheme, e.g. https://artifactory.example.com
+func URL(host string) string {
+	return lookup(host).scheme + "://" + host
+}
+
+func lookup(host string) *endpoint {
+	endpoints.Lock()
+	e, ok := endpoints.m[host]
+	endpoints.Unlock()
+	if ok {
+		return e
+	}
+	defaultEndpoint.once.Do(func() {
+		// the default settings have no files to load
+		transport, _ := newTransport(config.HTTP{})
+		defaultEndpoint.e = &endpoint{scheme: "https", transport: transport}
+	})
+	return defaultEndpoint.e
+}
+
+// host returns the host of a registry, which may be followed by a path, e.g. a root directory
+func host(registry string) string {
+	return strings.SplitN(registry, "/", 2)[0]
+}
+
+type router struct{}
+
+func (router) RoundTrip(req *http.Request) (*http.Response, error) {
+	return lookup(req.URL.Host).transport.RoundTrip(req)
+}
+
+// newTransport returns a transport with the timeouts, certificates and proxy of the settings
+func newTransport(s config.HTTP) (http.RoundTripper, error) {
+	connectTimeout := time.Duration(s.ConnectTimeout)
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	readTimeout := time.Duration(s.ReadTimeout)
+	if readTimeout == 0 {
+		readTimeout = defaultReadTimeout
+	}
+	maxIdleConns := s.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	tlsConfig, err := newTLSConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	proxyConfig := httpproxy.FromEnvironment()
+	if s.Proxy != "" {
+		proxyConfig.HTTPProxy = s.Proxy
+		proxyConfig.HTTPSProxy = s.Proxy
+	}
+	if s.NoProxy != "" {
+		proxyConfig.NoProxy = s.NoProxy
+	}
+	proxy := proxyConfig.ProxyFunc()
+	var transport http.RoundTripper = &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		},
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+			defer cancel()
+			dialer.RLock()
+			dial := dialer.dial
+			dialer.RUnlock()
+			return dial(ctx, network, address)
+		},
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: readTimeout,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+	}
+	if s.Timeout != 0 {
+		transport = timeoutTransport{base: transport, timeout: time.Duration(s.Timeout)}
+	}
+	return transport, nil
+}
+
+// newTLSConfig returns the TLS config trusting the CA bundle and presenting the client certificate of the settings
+func newTLSConfig(s config.HTTP) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if s.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(s.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no PEM certificate in CA bundle " + s.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if s.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(s.ClientCert, s.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// timeoutTransport limits the whole request to the timeout, until the response body is closed
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,321 @@
+package job
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/loqutus/artifactory-replication/pkg/binary"
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/docker"
+	"github.com/loqutus/artifactory-replication/pkg/ecr"
+	"github.com/loqutus/artifactory-replication/pkg/helm"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/notify"
+	"github.com/loqutus/artifactory-replication/pkg/plan"
+	"github.com/loqutus/artifactory-replication/pkg/repos"
+	"github.com/loqutus/artifactory-replication/pkg/result"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// Outcome is the result of one job of the config
+type Outcome struct {
+	Job    string
+	Result *result.Result
+	Err    error
+}
+
+// RunAll runs the jobs of the config one after another, or at the same time if the config is parallel,
+// then sends the run report to the notifiers of the jobs
+func RunAll(c *config.Config) []Outcome {
+	outcomes := forEach(c, func(j config.Job) Outcome {
+		res, err := Run(j)
+		metrics.RecordJob(j.Name, res, err)
+		return Outcome{Job: j.Name, Result: res, Err: err}
+	})
+	Notify(c, outcomes)
+	return outcomes
+}
+
+// PlanAll plans every job of the config into one plan, the outcomes only hold the planning errors
+func PlanAll(c *config.Config) (*plan.Plan, []Outcome) {
+	p := plan.New()
+	outcomes := forEach(c, func(j config.Job) Outcome {
+		return Outcome{Job: j.Name, Err: jobError(j, Plan(j, p))}
+	})
+	Notify(c, outcomes)
+	return p, outcomes
+}
+
+// ApplyAll runs the actions of a saved plan with the jobs of the config, which provide the credentials.
+// Every job of the plan must be in the config.
+func ApplyAll(c *config.Config, p *plan.Plan) []Outcome {
+	jobs := make(map[string]bool)
+	for _, j := range c.Jobs {
+		jobs[j.Name] = true
+	}
+	var outcomes []Outcome
+	for _, a := range p.Actions {
+		if !jobs[a.Job] {
+			jobs[a.Job] = true
+			outcomes = append(outcomes, Outcome{Job: a.Job, Err: fmt.Errorf("%w: job %s of the plan not found in the config", config.ErrInvalid, a.Job)})
+		}
+	}
+	if len(outcomes) != 0 {
+		return outcomes
+	}
+	outcomes = forEach(c, func(j config.Job) Outcome {
+		res, err := Apply(j, p.Job(j.Name))
+		metrics.RecordJob(j.Name, res, err)
+		return Outcome{Job: j.Name, Result: res, Err: jobError(j, err)}
+	})
+	Notify(c, outcomes)
+	return outcomes
+}
+
+// Notify sends the summaries of the outcomes to the notifiers of their jobs, one report per notifier
+func Notify(c *config.Config, outcomes []Outcome) {
+	var summaries []notify.Summary
+	for _, outcome := range outcomes {
+		for _, j := range c.Jobs {
+			if j.Name != outcome.Job {
+				continue
+			}
+			s, ok := notify.Summarize(j, outcome.Result, outcome.Err)
+			if ok {
+				summaries = append(summaries, s)
+			}
+		}
+	}
+	notify.Send(c.Jobs, summaries)
+}
+
+// forEach calls f for every job of the config, one after another or at the same time if the config is parallel
+func forEach(c *config.Config, f func(j config.Job) Outcome) []Outcome {
+	outcomes := make([]Outcome, len(c.Jobs))
+	var wg sync.WaitGroup
+	for i, j := range c.Jobs {
+		if !c.Parallel {
+			outcomes[i] = f(j)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, j config.Job) {
+			defer wg.Done()
+			outcomes[i] = f(j)
+		}(i, j)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// Run checks the job, or plans it and applies the plan right away. The outcome isn't sent to the notifiers, see Notify.
+func Run(j config.Job) (*result.Result, error) {
+	return RunPlanned(j, nil)
+}
+
+// RunPlanned is Run calling planned, if not nil, with the actions of the job before applying them, e.g. to report progress
+func RunPlanned(j config.Job, planned func(actions []plan.Action)) (*result.Result, error) {
+	logger.Job(j).Info("Running job")
+	if j.Check {
+		res, err := repos.Check(j)
+		return res, jobError(j, err)
+	}
+	err := j.Validate()
+	if err != nil {
+		return nil, jobError(j, err)
+	}
+	// one ECR login for the plan and the apply
+	j, client, err := login(j)
+	if err != nil {
+		return nil, jobError(j, err)
+	}
+	p := plan.New()
+	err = planJob(j, p)
+	if err != nil {
+		return nil, jobError(j, err)
+	}
+	actions := p.Job(j.Name)
+	if planned != nil {
+		planned(actions)
+	}
+	res, err := apply(j, client, actions)
+	return res, jobError(j, err)
+}
+
+// jobError adds the job name to the error
+func jobError(j config.Job, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("job %s: %w", j.Name, err)
+}
+
+// Plan adds to p what the job would copy, overwrite, delete or skip, without changing the destination
+func Plan(j config.Job, p *plan.Plan) error {
+	err := j.Validate()
+	if err != nil {
+		return err
+	}
+	j, _, err = login(j)
+	if err != nil {
+		return err
+	}
+	return planJob(j, p)
+}
+
+// planJob is Plan for a valid job, logged in to ECR
+func planJob(j config.Job, p *plan.Plan) error {
+	if j.Check {
+		return fmt.Errorf("%w: check jobs can't be planned", config.ErrInvalid)
+	}
+	if j.Type == "docker" {
+		return docker.Plan(j, p)
+	}
+	if j.Clean.Enabled {
+		return binary.PlanClean(j, p)
+	}
+	if j.Narrow != "" {
+		return binary.Plan(j, j.Narrow, p)
+	}
+	err := binary.Plan(j, j.Filter, p)
+	if err != nil {
+		return err
+	}
+	if j.FilterProd != "" {
+		return binary.Plan(j, j.FilterProd, p)
+	}
+	return nil
+}
+
+// Apply runs the planned actions of the job
+func Apply(j config.Job, actions []plan.Action) (*result.Result, error) {
+	err := j.Validate()
+	if err != nil {
+		return nil, err
+	}
+	j, client, err := login(j)
+	if err != nil {
+		return nil, err
+	}
+	return apply(j, client, actions)
+}
+
+// apply is Apply for a valid job, logged in to ECR with client if its destination is aws
+func apply(j config.Job, client *ecr.Client, actions []plan.Action) (*result.Result, error) {
+	if j.Type == "docker" {
+		return applyDocker(j, client, actions)
+	}
+	return applyBinary(j, actions)
+}
+
+// login replaces the destination credentials of aws jobs with an ECR token, returning the ECR client of the job.
+// The destination user and password are the access keys, the default AWS credentials are used if they are empty.
+func login(j config.Job) (config.Job, *ecr.Client, error) {
+	if j.Type != "docker" || j.Destination.Type != "aws" {
+		return j, nil, nil
+	}
+	client, err := ecr.NewClient(j.Destination.User, j.Destination.Password)
+	if err != nil {
+		return j, nil, fmt.Errorf("creating ECR client: %w", err)
+	}
+	ECRLogin, ECRPassword, err := client.Token()
+	if err != nil {
+		return j, nil, fmt.Errorf("getting ECR token: %w", err)
+	}
+	j.Destination.User = ECRLogin
+	j.Destination.Password = ECRPassword
+	return j, client, nil
+}
+
+func applyDocker(j config.Job, client *ecr.Client, actions []plan.Action) (*result.Result, error) {
+	l := logger.Job(j)
+	if j.Filter != "" {
+		l.Info("Replicating docker images", "repo", j.Filter)
+	} else {
+		l.Info("Replicating docker images")
+	}
+	if client != nil {
+		created := make(map[string]bool)
+		for _, a := range actions {
+			if (a.Op == plan.OpCopy || a.Op == plan.OpOverwrite) && !created[a.Repo] {
+				l.Info("Creating destination repo", "repo", a.Repo)
+				err := client.CreateRepository(a.Repo)
+				if err != nil {
+					return nil, fmt.Errorf("creating repo %s in %s: %w", a.Repo, j.Destination.Registry, err)
+				}
+				created[a.Repo] = true
+			}
+		}
+	}
+	res, err := docker.Apply(j, actions)
+	if err != nil {
+		return res, err
+	}
+	if res.HasFailures() {
+		for _, op := range []string{"push", "pull", "compare", "clean", "delete"} {
+			failed := res.FailedNames(op)
+			if len(failed) != 0 {
+				l.Error("Docker operations failed", "op", op, "images", failed)
+			}
+		}
+	}
+	return res, nil
+}
+
+func applyBinary(j config.Job, actions []plan.Action) (*result.Result, error) {
+	l := logger.Job(j)
+	if j.Binary.SyncPattern != "" {
+		l.Info("Sync pattern", "pattern", j.Binary.SyncPattern)
+	}
+	if j.Binary.HelmCdnDomain != "" {
+		l.Info("Helm CDN domain", "domain", j.Binary.HelmCdnDomain)
+	}
+	res, err := binary.Apply(j, actions)
+	if err != nil {
+		return res, err
+	}
+	if j.Clean.Enabled {
+		l.Info("Cleaned destination", "count", len(res.Deleted))
+		return res, nil
+	}
+	var replicatedRealArtifacts, replicatedRealArtifactsProd []string
+	for _, name := range res.CopiedNames(false) {
+		if j.FilterProd != "" && strings.HasPrefix(name, j.FilterProd+"/") {
+			replicatedRealArtifactsProd = append(replicatedRealArtifactsProd, name)
+		} else {
+			replicatedRealArtifacts = append(replicatedRealArtifacts, name)
+		}
+	}
+	l.Info("Real artifacts copied", "repo", j.Filter, "count", len(replicatedRealArtifacts))
+	l.Info("Forced artifacts copied", "count", len(res.CopiedNames(true)))
+	if j.FilterProd != "" {
+		l.Info("Real artifacts copied", "repo", j.FilterProd, "count", len(replicatedRealArtifactsProd))
+	}
+	repoName := strings.Split(j.Filter, "/")[0]
+	var repoNameProd string
+	if j.FilterProd != "" {
+		repoNameProd = strings.Split(j.FilterProd, "/")[0]
+	}
+	if (len(replicatedRealArtifacts) != 0 || len(replicatedRealArtifactsProd) != 0) && j.FilterProd != "" {
+		storageFor := func(repo string) (storage.Storage, error) {
+			return binary.NewStorage(j, repo)
+		}
+		err := helm.RegenerateIndexYaml(replicatedRealArtifacts, replicatedRealArtifactsProd, j.Source.Registry, storageFor, repoName, repoNameProd, j.Binary.HelmCdnDomain)
+		if err != nil {
+			return res, fmt.Errorf("regenerating index.yaml: %w", err)
+		}
+	}
+	if res.HasFailures() {
+		failedUploads := res.FailedNames("upload")
+		failedDownloads := res.FailedNames("download")
+		if len(failedUploads) != 0 {
+			l.Error("Upload failed", "files", failedUploads)
+		}
+		if len(failedDownloads) != 0 {
+			l.Error("Artifactory download failed", "files", failedDownloads)
+		}
+	}
+	return res, nil
+}
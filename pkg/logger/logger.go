@@ -0,0 +1,298 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+)
+
+// Level is the severity of a message, messages below the configured level are dropped
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn", LevelError: "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("%w: unknown log level %q, expected debug, info, warn or error", config.ErrInvalid, name)
+}
+
+// output is where and how every logger writes
+var output = struct {
+	sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+}{w: os.Stderr, format: "text", level: LevelInfo}
+
+// Configure sets the format, text, logfmt or json, and the minimum level of the messages.
+// The messages of the standard log package are written as info messages of the same format.
+func Configure(format string, level string) error {
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "logfmt" && format != "json" {
+		return fmt.Errorf("%w: unknown log format %q, expected text, logfmt or json", config.ErrInvalid, format)
+	}
+	l := LevelInfo
+	if level != "" {
+		var err error
+		l, err = ParseLevel(level)
+		if err != nil {
+			return err
+		}
+	}
+	output.Lock()
+	output.format = format
+	output.level = l
+	output.Unlock()
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+	return nil
+}
+
+// SetOutput sets where the messages are written, stderr by default
+func SetOutput(w io.Writer) {
+	output.Lock()
+	defer output.Unlock()
+	output.w = w
+}
+
+// Enabled tells whether messages of the level are written
+func Enabled(level Level) bool {
+	output.Lock()
+	defer output.Unlock()
+	return level >= output.level
+}
+
+// Entry is a written message, as given to the hooks
+type Entry struct {
+	Time   time.Time              `json:"time"`
+	Level  string                 `json:"level"`
+	Msg    string                 `json:"msg"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+var hooks struct {
+	sync.Mutex
+	list []func(e Entry)
+}
+
+// Hook calls f with every message written by a logger, e.g. to stream the messages of a job.
+// f must not write messages itself.
+func Hook(f func(e Entry)) {
+	hooks.Lock()
+	defer hooks.Unlock()
+	hooks.list = append(hooks.list, f)
+}
+
+// runHooks calls the hooks with the message
+func runHooks(t time.Time, level Level, msg string, fields []interface{}) {
+	hooks.Lock()
+	list := hooks.list
+	hooks.Unlock()
+	if len(list) == 0 {
+		return
+	}
+	e := Entry{Time: t, Level: level.String(), Msg: msg}
+	if len(fields) != 0 {
+		e.Fields = make(map[string]interface{}, len(fields)/2)
+		pairs(fields, func(key string, value interface{}) {
+			e.Fields[key] = plain(value)
+		})
+	}
+	for _, f := range list {
+		f(e)
+	}
+}
+
+// Logger writes messages with its context fields, e.g. the job and the artifact
+type Logger struct {
+	// fields are key value pairs
+	fields []interface{}
+}
+
+var root = &Logger{}
+
+// With returns a logger with the key value fields, e.g. With("repo", repo, "tag", tag)
+func With(keyValues ...interface{}) *Logger {
+	return root.With(keyValues...)
+}
+
+// Job returns a logger with the job, source and destination fields of the job
+func Job(j config.Job) *Logger {
+	return root.With("job", j.Name, "source", j.Source.Registry, "destination", j.Destination.Registry)
+}
+
+// With returns a logger with the fields of l and the key value fields
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+	return &Logger{fields: fields}
+}
+
+func (l *Logger) Debug(msg string, keyValues ...interface{}) { l.write(LevelDebug, msg, keyValues) }
+func (l *Logger) Info(msg string, keyValues ...interface{})  { l.write(LevelInfo, msg, keyValues) }
+func (l *Logger) Warn(msg string, keyValues ...interface{})  { l.write(LevelWarn, msg, keyValues) }
+func (l *Logger) Error(msg string, keyValues ...interface{}) { l.write(LevelError, msg, keyValues) }
+
+func Debug(msg string, keyValues ...interface{}) { root.write(LevelDebug, msg, keyValues) }
+func Info(msg string, keyValues ...interface{})  { root.write(LevelInfo, msg, keyValues) }
+func Warn(msg string, keyValues ...interface{})  { root.write(LevelWarn, msg, keyValues) }
+func Error(msg string, keyValues ...interface{}) { root.write(LevelError, msg, keyValues) }
+
+// write formats the message with the caller of the Debug, Info, Warn or Error function
+func (l *Logger) write(level Level, msg string, keyValues []interface{}) {
+	if !Enabled(level) {
+		return
+	}
+	caller := ""
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	fields := append(append([]interface{}{}, l.fields...), keyValues...)
+	t := time.Now()
+	writeEntry(t, level, caller, msg, fields)
+	runHooks(t, level, msg, fields)
+}
+
+func writeEntry(t time.Time, level Level, caller string, msg string, fields []interface{}) {
+	output.Lock()
+	defer output.Unlock()
+	var b bytes.Buffer
+	switch output.format {
+	case "json":
+		writeJSON(&b, t, level, caller, msg, fields)
+	case "logfmt":
+		writeLogfmt(&b, t, level, caller, msg, fields)
+	default:
+		writeText(&b, t, level, caller, msg, fields)
+	}
+	b.WriteByte('\n')
+	output.w.Write(b.Bytes())
+}
+
+// pairs calls f with every key and value of the fields, a trailing key without value gets nil
+func pairs(fields []interface{}, f func(key string, value interface{})) {
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		var value interface{}
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		f(key, value)
+	}
+}
+
+// plain returns the value as JSON can encode it: errors and durations as their string
+func plain(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+func writeJSON(b *bytes.Buffer, t time.Time, level Level, caller string, msg string, fields []interface{}) {
+	writeJSONField(b, "time", t.Format(time.RFC3339Nano), true)
+	writeJSONField(b, "level", level.String(), false)
+	if caller != "" {
+		writeJSONField(b, "caller", caller, false)
+	}
+	writeJSONField(b, "msg", msg, false)
+	pairs(fields, func(key string, value interface{}) {
+		writeJSONField(b, key, plain(value), false)
+	})
+	b.WriteByte('}')
+}
+
+func writeJSONField(b *bytes.Buffer, key string, value interface{}, first bool) {
+	if first {
+		b.WriteByte('{')
+	} else {
+		b.WriteByte(',')
+	}
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(k)
+	b.WriteByte(':')
+	b.Write(v)
+}
+
+func writeLogfmt(b *bytes.Buffer, t time.Time, level Level, caller string, msg string, fields []interface{}) {
+	b.WriteString("time=" + t.Format(time.RFC3339Nano) + " level=" + level.String())
+	if caller != "" {
+		b.WriteString(" caller=" + caller)
+	}
+	b.WriteString(" msg=" + logfmtValue(msg))
+	pairs(fields, func(key string, value interface{}) {
+		b.WriteString(" " + key + "=" + logfmtValue(fmt.Sprint(plain(value))))
+	})
+}
+
+// writeText writes the message the way the standard log package did, followed by the fields
+func writeText(b *bytes.Buffer, t time.Time, level Level, caller string, msg string, fields []interface{}) {
+	b.WriteString(t.Format("2006/01/02 15:04:05") + " ")
+	if caller != "" {
+		b.WriteString(caller + ": ")
+	}
+	if level != LevelInfo {
+		b.WriteString(strings.ToUpper(level.String()) + " ")
+	}
+	b.WriteString(msg)
+	pairs(fields, func(key string, value interface{}) {
+		b.WriteString(" " + key + "=" + logfmtValue(fmt.Sprint(plain(value))))
+	})
+}
+
+// logfmtValue quotes the value if it is empty or has spaces, quotes, equal signs or control characters
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\t\r\n\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// stdWriter writes the lines of the standard log package as info messages
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	if Enabled(LevelInfo) {
+		writeEntry(time.Now(), LevelInfo, "", strings.TrimSuffix(string(p), "\n"), nil)
+	}
+	return len(p), nil
+}
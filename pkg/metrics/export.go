@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves every metric in the Prometheus text format, for scraping
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		err := writeText(w, nil)
+		if err != nil {
+			logger.Error("Error writing metrics", "err", err)
+		}
+	})
+}
+
+// Serve serves the metrics on /metrics at the address, e.g. :9090, until the process exits
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	logger.Info("Serving metrics", "address", address+"/metrics")
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			logger.Error("Error serving metrics", "err", err)
+		}
+	}()
+}
+
+// Push sends the metrics to the Pushgateway at gateway, e.g. http://pushgateway:9091, under the push job name.
+// The metrics of every replication job go to their own group, with a POST that keeps the metrics of the group
+// that aren't pushed, so that the last success time of a job that failed this time stays in the Pushgateway.
+func Push(gateway string, job string) error {
+	groups := map[string]bool{"": true}
+	for _, name := range jobNames() {
+		groups[name] = true
+	}
+	for name := range groups {
+		var body bytes.Buffer
+		err := writeText(&body, func(labels []string, values []string) bool {
+			return labelValue(labels, values, jobLabel) == name
+		})
+		if err != nil {
+			return err
+		}
+		if body.Len() == 0 {
+			continue
+		}
+		u := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+		if name != "" {
+			u += "/" + jobLabel + "/" + url.PathEscape(name)
+		}
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := retry.HTTP(httpclient.Client(), req)
+		if err != nil {
+			return err
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			return errors.New("pushing metrics to " + u + " returned " + resp.Status + ": " + strings.TrimSpace(string(respBody)))
+		}
+	}
+	logger.Info("Metrics pushed", "gateway", gateway)
+	return nil
+}
+
+// WriteFile writes the metrics to the file in the text format, through a temp file and a rename,
+// e.g. for the node exporter textfile collector. The last success times of the jobs without one in this run,
+// e.g. because they failed, are kept from the previous file.
+func WriteFile(path string) error {
+	loadLastSuccess(path)
+	var body bytes.Buffer
+	err := writeText(&body, nil)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(body.Bytes())
+	if err == nil {
+		err = temp.Chmod(0644)
+	}
+	if err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	err = temp.Close()
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	logger.Info("Metrics written", "path", path)
+	return nil
+}
+
+// loadLastSuccess sets the last success times of the previous file for the jobs without one in this run
+func loadLastSuccess(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	current := make(map[string]bool)
+	for _, name := range lastSuccessJobs() {
+		current[name] = true
+	}
+	prefix := JobLastSuccess.f.name + "{" + jobLabel + `="`
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(line, prefix), `"} `, 2)
+		if len(parts) != 2 || current[parts[0]] {
+			continue
+		}
+		v, err := strconv.ParseFloat(parts[1], 64)
+		if err == nil {
+			JobLastSuccess.Set(v, parts[0])
+		}
+	}
+}
+
+// jobNames returns the job names of every series
+func jobNames() []string {
+	families.Lock()
+	list := append([]*family(nil), families.list...)
+	families.Unlock()
+	seen := make(map[string]bool)
+	var names []string
+	for _, f := range list {
+		f.mu.Lock()
+		for _, s := range f.series {
+			name := labelValue(f.labels, s.labels, jobLabel)
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		f.mu.Unlock()
+	}
+	return names
+}
+
+// lastSuccessJobs returns the jobs with a last success time in this run
+func lastSuccessJobs() []string {
+	f := JobLastSuccess.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, s := range f.series {
+		names = append(names, s.labels[0])
+	}
+	return names
+}
+
+func labelValue(labels []string, values []string, name string) string {
+	for i, label := range labels {
+		if label == name {
+			return values[i]
+		}
+	}
+	return ""
+}
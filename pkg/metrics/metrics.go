@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// family is a metric with its series by label values
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+	// counts are the observations per bucket of histograms, not cumulative
+	counts []uint64
+	count  uint64
+}
+
+// families are the registered metrics, written in registration order
+var families struct {
+	sync.Mutex
+	list []*family
+}
+
+func register(name string, help string, kind string, buckets []float64, labels []string) *family {
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	families.Lock()
+	defer families.Unlock()
+	families.list = append(families.list, f)
+	return f
+}
+
+// get returns the series of the label values, creating it, f.mu must be held
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic("metric " + f.name + " has labels " + strings.Join(f.labels, ", ") + ", got " + strconv.Itoa(len(values)) + " values")
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a metric that only goes up, e.g. a number of copied artifacts
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter with the label names
+func NewCounter(name string, help string, labels ...string) Counter {
+	return Counter{f: register(name, help, "counter", nil, labels)}
+}
+
+// Add adds v to the series of the label values
+func (c Counter) Add(v float64, values ...string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(values).value += v
+}
+
+// Inc adds 1 to the series of the label values
+func (c Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Gauge is a metric set to the current value, e.g. a timestamp
+type Gauge struct {
+	f *family
+}
+
+// NewGauge registers a gauge with the label names
+func NewGauge(name string, help string, labels ...string) Gauge {
+	return Gauge{f: register(name, help, "gauge", nil, labels)}
+}
+
+// Set sets the series of the label values to v
+func (g Gauge) Set(v float64, values ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(values).value = v
+}
+
+// Histogram counts observations, e.g. durations, in buckets
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram registers a histogram with the bucket upper bounds, in increasing order, and the label names
+func NewHistogram(name string, help string, buckets []float64, labels ...string) Histogram {
+	return Histogram{f: register(name, help, "histogram", buckets, labels)}
+}
+
+// Observe adds v to the series of the label values
+func (h Histogram) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(values)
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// writeText writes the series the filter keeps in the Prometheus text format.
+// The filter gets the label names and values of every series.
+func writeText(w io.Writer, filter func(labels []string, values []string) bool) error {
+	families.Lock()
+	list := append([]*family(nil), families.list...)
+	families.Unlock()
+	b := bufio.NewWriter(w)
+	for _, f := range list {
+		f.write(b, filter)
+	}
+	return b.Flush()
+}
+
+func (f *family) write(w *bufio.Writer, filter func(labels []string, values []string) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key, s := range f.series {
+		if filter == nil || filter(f.labels, s.labels) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelText(f.labels, s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(f.labels, s.labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(f.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelText(f.labels, s.labels, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelText(f.labels, s.labels, "", ""), s.count)
+	}
+}
+
+// labelText returns the {name="value",...} part of a series line, with the extra label if its name isn't empty
+func labelText(names []string, values []string, extraName string, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		// timestamps and counts without an exponent
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/result"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// jobLabel is the label of the job name. Not "job", which Prometheus and the Pushgateway set to the scrape or push job.
+const jobLabel = "job_name"
+
+// operationBuckets are the upper bounds of the operation durations, from a HEAD request to a large image push
+var operationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+var (
+	// Artifacts counts the images, tags and files handled by the jobs, by result: copied, skipped, deleted or failed
+	Artifacts = NewCounter("replication_artifacts_total", "Artifacts handled by the jobs, by result: copied, skipped, deleted or failed.", jobLabel, "result")
+	// Bytes counts the bytes copied by the jobs
+	Bytes = NewCounter("replication_bytes_total", "Bytes copied by the jobs.", jobLabel)
+	// Failures counts the failed artifacts and aborted jobs, by operation and cause
+	Failures = NewCounter("replication_failures_total", "Failed artifacts and aborted jobs, by operation and cause.", jobLabel, "op", "cause")
+	// Operations is the duration of the registry and storage operations
+	Operations = NewHistogram("replication_operation_duration_seconds", "Duration of the registry and storage operations: list, pull, push, copy, download, upload, stat or delete.", operationBuckets, "op")
+	// Retries counts the calls retried after a failure
+	Retries = NewCounter("replication_retries_total", "Network calls retried after a failure.")
+	// JobDuration is the duration of the last run of every job
+	JobDuration = NewGauge("replication_job_duration_seconds", "Duration of the last run of the job.", jobLabel)
+	// JobLastRun is the end time of the last run of every job
+	JobLastRun = NewGauge("replication_job_last_run_timestamp_seconds", "End time of the last run of the job, as a unix timestamp.", jobLabel)
+	// JobLastSuccess is the end time of the last run of every job without any failure, to alert on stale replications
+	JobLastSuccess = NewGauge("replication_job_last_success_timestamp_seconds", "End time of the last run of the job without any failure, as a unix timestamp.", jobLabel)
+)
+
+func init() {
+	retry.OnRetry = func() { Retries.Inc() }
+}
+
+// ObserveOperation records the duration of the operation started at started, e.g. defer ObserveOperation("push", time.Now())
+func ObserveOperation(op string, started time.Time) {
+	Operations.Observe(time.Since(started).Seconds(), op)
+}
+
+// RecordJob records the result of a run of the job, and err if it was aborted
+func RecordJob(name string, res *result.Result, err error) {
+	now := time.Now()
+	if res != nil {
+		res.Each(func(kind string, item result.Item) {
+			Artifacts.Inc(name, kind)
+			if kind == "failed" {
+				Failures.Inc(name, item.Op, itemCause(item))
+			}
+		})
+		Bytes.Add(float64(res.TotalBytes()), name)
+		JobDuration.Set(res.Duration.Seconds(), name)
+	}
+	if err != nil {
+		Failures.Inc(name, "job", Cause(err))
+	}
+	JobLastRun.Set(float64(now.Unix()), name)
+	if err == nil && (res == nil || !res.HasFailures()) {
+		JobLastSuccess.Set(float64(now.Unix()), name)
+	}
+}
+
+func itemCause(item result.Item) string {
+	if item.Err == nil && item.Op == "check" {
+		// missing or different at destination
+		return "mismatch"
+	}
+	return Cause(item.Err)
+}
+
+// Cause classifies an error for the failures metric: config, not_found, timeout, network, auth, checksum or other.
+// Only typed errors are classified, the HTTP status of the registries and storages, and other errors are other.
+func Cause(err error) string {
+	if err == nil {
+		return "other"
+	}
+	if errors.Is(err, config.ErrInvalid) {
+		return "config"
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return "not_found"
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	if netErr != nil {
+		return "network"
+	}
+	if errors.Is(err, storage.ErrNoChecksum) {
+		return "checksum"
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return "auth"
+	}
+	switch statusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "auth"
+	case http.StatusNotFound:
+		return "not_found"
+	}
+	return "other"
+}
+
+// statusCode returns the HTTP status of a registry or storage error, from retry.HTTPError or the StatusCode method
+// of the SDK errors, or 0
+func statusCode(err error) int {
+	var httpErr *retry.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+	var sdkErr interface{ StatusCode() int }
+	if errors.As(err, &sdkErr) {
+		return sdkErr.StatusCode()
+	}
+	return 0
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// sdkError has the StatusCode method of the AWS SDK request failures
+type sdkError struct{ code int }
+
+func (e sdkError) Error() string   { return "sdk error" }
+func (e sdkError) StatusCode() int { return e.code }
+
+func TestCause(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		cause string
+	}{
+		{nil, "other"},
+		{fmt.Errorf("job a: %w", config.ErrInvalid), "config"},
+		{storage.ErrNotFound, "not_found"},
+		{retry.NewHTTPError(http.StatusNotFound, "GET url returned 404 Not Found"), "not_found"},
+		{fmt.Errorf("listing: %w", retry.NewHTTPError(http.StatusUnauthorized, "GET url returned 401 Unauthorized")), "auth"},
+		{retry.NewHTTPError(http.StatusForbidden, "GET url returned 403 Forbidden"), "auth"},
+		{sdkError{http.StatusForbidden}, "auth"},
+		{&os.PathError{Op: "open", Path: "/root/file", Err: os.ErrPermission}, "auth"},
+		{fmt.Errorf("%w of repo/file", storage.ErrNoChecksum), "checksum"},
+		{retry.NewHTTPError(http.StatusInternalServerError, "GET url returned 500 Internal Server Error"), "other"},
+		// messages holding the words or codes of other causes
+		{errors.New("pushing sha256:4034e401fa: blob unknown"), "other"},
+		{errors.New("manifest digest of image:403 not found in the index"), "other"},
+		{errors.New("access denied by the webhook filter"), "other"},
+	} {
+		if cause := Cause(tc.err); cause != tc.cause {
+			t.Errorf("Cause(%v) = %s, expected %s", tc.err, cause, tc.cause)
+		}
+	}
+}
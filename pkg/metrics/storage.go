@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// instrumented records the duration of the operations of a storage
+type instrumented struct {
+	st storage.Storage
+}
+
+// Storage returns the storage recording the duration of its operations: list, stat, download, upload and delete
+func Storage(st storage.Storage) storage.Storage {
+	return instrumented{st: st}
+}
+
+func (s instrumented) List(prefix string) ([]storage.Object, error) {
+	defer ObserveOperation("list", time.Now())
+	return s.st.List(prefix)
+}
+
+func (s instrumented) Stat(key string) (storage.Object, error) {
+	defer ObserveOperation("stat", time.Now())
+	return s.st.Stat(key)
+}
+
+func (s instrumented) Get(key string, path string) error {
+	defer ObserveOperation("download", time.Now())
+	return s.st.Get(key, path)
+}
+
+func (s instrumented) Put(key string, path string) error {
+	defer ObserveOperation("upload", time.Now())
+	return s.st.Put(key, path)
+}
+
+func (s instrumented) Delete(key string) error {
+	defer ObserveOperation("delete", time.Now())
+	return s.st.Delete(key)
+}
+
+func (s instrumented) SHA256(key string) (string, error) {
+	defer ObserveOperation("stat", time.Now())
+	return s.st.SHA256(key)
+}
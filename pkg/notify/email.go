@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+)
+
+// Email sends the report as a plain text email through an SMTP server, with STARTTLS when the server offers it
+type Email struct {
+	// Server is host:port
+	Server string
+	// User and Password authenticate with PLAIN auth, not authenticated if User is empty
+	User     string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *Email) Notify(r Report) error {
+	var msg bytes.Buffer
+	msg.WriteString("From: " + e.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(e.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", r.Title()) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(r.Text(), "\n", "\r\n", -1))
+	var auth smtp.Auth
+	if e.User != "" {
+		host, _, _ := net.SplitHostPort(e.Server)
+		auth = smtp.PlainAuth("", e.User, e.Password, host)
+	}
+	return retry.Do(context.Background(), "sending email through "+e.Server, func() error {
+		return smtp.SendMail(e.Server, auth, e.From, e.To, msg.Bytes())
+	})
+}
@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+)
+
+// timeout limits every notification request, a slow chat service must not hold the run
+const timeout = 10 * time.Second
+
+// post sends the JSON body to the URL, returning the response body or an error for a status other than 2xx
+func post(url string, body []byte) ([]byte, error) {
+	return postAs(url, "application/json", "", body)
+}
+
+// postAs sends the body of the content type to the URL with the bearer token, if set
+func postAs(url string, contentType string, token string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Transport: httpclient.Transport, Timeout: timeout}
+	resp, err := retry.HTTP(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return respBody, errors.New("POST " + req.URL.Host + " returned " + resp.Status + ": " + strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+)
+
+// Notifier sends the report of a run somewhere, e.g. to a slack channel
+type Notifier interface {
+	Notify(r Report) error
+}
+
+// New returns the notifier of the config
+func New(n config.Notifier) (Notifier, error) {
+	switch n.Type {
+	case "slack":
+		return &Slack{Webhook: n.URL, Token: n.Token, Channel: n.Channel, User: n.User, MaxItems: n.MaxItems}, nil
+	case "teams":
+		return &Teams{Webhook: n.URL}, nil
+	case "email":
+		return &Email{Server: n.SMTP, User: n.SMTPUser, Password: n.SMTPPassword, From: n.From, To: n.To}, nil
+	case "webhook":
+		return NewWebhook(n.URL, n.Template)
+	}
+	return nil, fmt.Errorf("%w: unknown notifier type %q", config.ErrInvalid, n.Type)
+}
+
+// notifiers returns the notifiers of the job, with the slack webhook or token of the job as a slack notifier of failures and drift
+func notifiers(j config.Job) []config.Notifier {
+	var list []config.Notifier
+	if j.Notify.SlackWebhook != "" || j.Notify.SlackToken != "" {
+		list = append(list, config.Notifier{
+			Type:     "slack",
+			On:       []string{Failure, Drift},
+			URL:      j.Notify.SlackWebhook,
+			Token:    j.Notify.SlackToken,
+			Channel:  j.Notify.SlackChannel,
+			User:     j.Notify.SlackUser,
+			MaxItems: j.Notify.SlackMaxItems,
+		})
+	}
+	return append(list, j.Notify.Notifiers...)
+}
+
+// sends tells whether the notifier is sent the summaries of the severity
+func sends(n config.Notifier, severity string) bool {
+	if len(n.On) == 0 {
+		return true
+	}
+	for _, s := range n.On {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// Send sends one report to every notifier of the jobs, with the summaries of its jobs of the severities it is sent.
+// Notifiers shared by several jobs get a single report. Notifiers failing to send are logged, not returned.
+func Send(jobs []config.Job, summaries []Summary) {
+	type route struct {
+		notifier config.Notifier
+		report   Report
+	}
+	var routes []*route
+	byKey := make(map[string]*route)
+	for _, s := range summaries {
+		for _, j := range jobs {
+			if j.Name != s.Job {
+				continue
+			}
+			for _, n := range notifiers(j) {
+				if !sends(n, s.Severity) {
+					continue
+				}
+				key := notifierKey(n)
+				r, ok := byKey[key]
+				if !ok {
+					r = &route{notifier: n}
+					byKey[key] = r
+					routes = append(routes, r)
+				}
+				if r.report.BuildURL == "" {
+					r.report.BuildURL = j.Notify.BuildURL
+				}
+				r.report.Jobs = append(r.report.Jobs, s)
+			}
+		}
+	}
+	for _, r := range routes {
+		l := logger.With("notifier", r.notifier.Type)
+		n, err := New(r.notifier)
+		if err == nil {
+			l.Info("Sending run report", "jobs", len(r.report.Jobs))
+			err = n.Notify(r.report)
+		}
+		if err != nil {
+			l.Error("Error sending run report", "err", err)
+		}
+	}
+}
+
+// notifierKey identifies the destination of a notifier, whatever severities it is sent
+func notifierKey(n config.Notifier) string {
+	n.On = nil
+	b, _ := json.Marshal(n)
+	return string(b)
+}
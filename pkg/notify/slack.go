@@ -0,0 +1,279 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// slackAPI is the root of the slack Web API
+var slackAPI = "https://slack.com/api/"
+
+// defaultMaxItems is how many artifacts of a section are listed in a slack message
+const defaultMaxItems = 20
+
+// slack Block Kit limits
+const (
+	maxBlocks      = 50
+	maxSectionText = 3000
+	maxHeaderText  = 150
+)
+
+// Slack posts the report as Block Kit messages, to an incoming webhook or with a bot token.
+// With a bot token, the run summary is the parent message: the artifacts of every job follow in its thread,
+// with a link to the full report uploaded as a file.
+type Slack struct {
+	Webhook string
+	// Token is the bot token, xoxb-..., used instead of the webhook when set
+	Token string
+	// Channel and User override the channel and user name of the webhook, Channel is required with a token
+	Channel string
+	User    string
+	// MaxItems caps the artifacts listed per section, 20 if not specified
+	MaxItems int
+}
+
+type block map[string]interface{}
+
+func (s *Slack) Notify(r Report) error {
+	if s.Token == "" {
+		blocks := summaryBlocks(r)
+		for _, job := range r.Jobs {
+			blocks = append(blocks, s.sectionBlocks(job, "")...)
+		}
+		return s.postWebhook(r.Title(), limitBlocks(blocks))
+	}
+	ts, err := s.postMessage(r.Title(), summaryBlocks(r), "")
+	if err != nil {
+		return err
+	}
+	var link string
+	var uploadErr error
+	for _, job := range r.Jobs {
+		if len(job.Sections) == 0 {
+			continue
+		}
+		if link == "" && uploadErr == nil {
+			link, uploadErr = s.uploadReport(r, ts)
+		}
+		blocks := append([]block{textSection("*" + escape(job.Heading()) + "*")}, s.sectionBlocks(job, link)...)
+		_, err := s.postMessage(job.Heading(), limitBlocks(blocks), ts)
+		if err != nil {
+			return err
+		}
+	}
+	if uploadErr != nil {
+		return fmt.Errorf("uploading the full report: %w", uploadErr)
+	}
+	return nil
+}
+
+// summaryBlocks are the header, the build link and the counts of every job
+func summaryBlocks(r Report) []block {
+	blocks := []block{{
+		"type": "header",
+		"text": block{"type": "plain_text", "text": truncate(r.Title(), maxHeaderText)},
+	}}
+	if r.BuildURL != "" {
+		blocks = append(blocks, block{
+			"type":     "context",
+			"elements": []block{{"type": "mrkdwn", "text": "<" + r.BuildURL + "|CI build>"}},
+		})
+	}
+	for _, job := range r.Jobs {
+		text := severityEmoji[job.Severity] + " *" + escape(job.Job) + "* " + escape(job.Source) + " → " + escape(job.Destination) + "\n" + escape(job.Text)
+		blocks = append(blocks, block{
+			"type": "section",
+			"text": block{"type": "mrkdwn", "text": truncate(text, maxSectionText)},
+			"fields": []block{
+				{"type": "mrkdwn", "text": "*Copied*\n" + thousands(job.Copied)},
+				{"type": "mrkdwn", "text": "*Skipped*\n" + thousands(job.Skipped)},
+				{"type": "mrkdwn", "text": "*Deleted*\n" + thousands(job.Deleted)},
+				{"type": "mrkdwn", "text": "*Failed*\n" + thousands(job.Failed)},
+			},
+		})
+	}
+	return blocks
+}
+
+var severityEmoji = map[string]string{Failure: ":x:", Drift: ":warning:", Cleanup: ":broom:"}
+
+// sectionBlocks list the artifacts of every section of the job, capped at MaxItems, with the link to the full report if any
+func (s *Slack) sectionBlocks(job Summary, link string) []block {
+	maxItems := s.MaxItems
+	if maxItems == 0 {
+		maxItems = defaultMaxItems
+	}
+	var blocks []block
+	for _, section := range job.Sections {
+		title := "*" + escape(section.Title) + "* (" + thousands(len(section.Items)) + ")\n"
+		// room for the title, the code fences and the overflow line
+		room := maxSectionText - len(title) - 200
+		var items []string
+		for _, item := range section.Items {
+			item = truncate(escape(item), room/2)
+			if len(items) == maxItems || len(item)+1 > room {
+				break
+			}
+			room -= len(item) + 1
+			items = append(items, item)
+		}
+		text := title + "```" + strings.Join(items, "\n") + "```"
+		if more := len(section.Items) - len(items); more > 0 {
+			text += "\n_and " + thousands(more) + " more_"
+			if link != "" {
+				text += ", see the <" + link + "|full report>"
+			}
+		}
+		blocks = append(blocks, textSection(text))
+	}
+	return blocks
+}
+
+func textSection(text string) block {
+	return block{"type": "section", "text": block{"type": "mrkdwn", "text": truncate(text, maxSectionText)}}
+}
+
+// limitBlocks keeps the blocks a message can hold, ending with a note of how many were left out
+func limitBlocks(blocks []block) []block {
+	if len(blocks) <= maxBlocks {
+		return blocks
+	}
+	left := len(blocks) - maxBlocks + 1
+	return append(blocks[:maxBlocks-1:maxBlocks-1], block{
+		"type":     "context",
+		"elements": []block{{"type": "mrkdwn", "text": "_" + strconv.Itoa(left) + " more sections not shown_"}},
+	})
+}
+
+func (s *Slack) postWebhook(text string, blocks []block) error {
+	body, err := json.Marshal(struct {
+		Text    string  `json:"text"`
+		Blocks  []block `json:"blocks"`
+		Channel string  `json:"channel,omitempty"`
+		User    string  `json:"username,omitempty"`
+	}{Text: text, Blocks: blocks, Channel: s.Channel, User: s.User})
+	if err != nil {
+		return err
+	}
+	resp, err := post(s.Webhook, body)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(resp)) != "ok" {
+		return errors.New("non-ok response returned from slack: " + string(resp))
+	}
+	return nil
+}
+
+// slackResponse holds the fields of the Web API responses used here
+type slackResponse struct {
+	OK        bool   `json:"ok"`
+	Error     string `json:"error"`
+	TS        string `json:"ts"`
+	UploadURL string `json:"upload_url"`
+	FileID    string `json:"file_id"`
+	Files     []struct {
+		Permalink string `json:"permalink"`
+	} `json:"files"`
+	File struct {
+		Permalink string `json:"permalink"`
+	} `json:"file"`
+}
+
+// call calls the Web API method with the JSON or form body, returning an error for a non-ok response
+func (s *Slack) call(method string, contentType string, body []byte) (*slackResponse, error) {
+	b, err := postAs(slackAPI+method, contentType, s.Token, body)
+	if err != nil {
+		return nil, err
+	}
+	var resp slackResponse
+	err = json.Unmarshal(b, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("slack %s: %w", method, err)
+	}
+	if !resp.OK {
+		return nil, errors.New("slack " + method + " returned " + resp.Error)
+	}
+	return &resp, nil
+}
+
+// postMessage posts the message to the channel, in the thread of threadTS if set, returning its ts
+func (s *Slack) postMessage(text string, blocks []block, threadTS string) (string, error) {
+	body, err := json.Marshal(struct {
+		Channel  string  `json:"channel"`
+		Text     string  `json:"text"`
+		Blocks   []block `json:"blocks"`
+		ThreadTS string  `json:"thread_ts,omitempty"`
+		User     string  `json:"username,omitempty"`
+	}{Channel: s.Channel, Text: text, Blocks: blocks, ThreadTS: threadTS, User: s.User})
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.call("chat.postMessage", "application/json; charset=utf-8", body)
+	if err != nil {
+		return "", err
+	}
+	return resp.TS, nil
+}
+
+// uploadReport uploads the report as text in the thread of threadTS, returning its permalink
+func (s *Slack) uploadReport(r Report, threadTS string) (string, error) {
+	content := []byte(r.Text())
+	form := url.Values{"filename": {"replication-report.txt"}, "length": {strconv.Itoa(len(content))}}
+	resp, err := s.call("files.getUploadURLExternal", "application/x-www-form-urlencoded", []byte(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	_, err = postAs(resp.UploadURL, "text/plain; charset=utf-8", "", content)
+	if err != nil {
+		return "", err
+	}
+	files, _ := json.Marshal([]map[string]string{{"id": resp.FileID, "title": r.Title()}})
+	form = url.Values{"files": {string(files)}, "channel_id": {s.Channel}, "thread_ts": {threadTS}}
+	done, err := s.call("files.completeUploadExternal", "application/x-www-form-urlencoded", []byte(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	if len(done.Files) != 0 && done.Files[0].Permalink != "" {
+		return done.Files[0].Permalink, nil
+	}
+	info, err := s.call("files.info", "application/x-www-form-urlencoded", []byte(url.Values{"file": {resp.FileID}}.Encode()))
+	if err != nil {
+		return "", err
+	}
+	return info.File.Permalink, nil
+}
+
+// truncate cuts the text to n bytes at most, on a rune boundary, ending with an ellipsis when cut
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	cut := n - len("…")
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
+
+// escape escapes the characters slack reserves for links and mentions
+func escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// thousands formats n with comma separators, e.g. 1,234
+func thousands(n int) string {
+	s := strconv.Itoa(n)
+	if n < 0 {
+		return "-" + thousands(-n)
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
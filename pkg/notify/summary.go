@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/result"
+)
+
+// severities of a job summary
+const (
+	// Failure is a job aborted by an error, or with failed artifacts
+	Failure = "failure"
+	// Drift is a check that found artifacts missing or different at destination
+	Drift = "drift"
+	// Cleanup is a cleanup that removed artifacts
+	Cleanup = "cleanup"
+)
+
+// Summary is the outcome of one job of a run
+type Summary struct {
+	Job         string `json:"job"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Severity    string `json:"severity"`
+	// Text is the outcome in one line, e.g. "3 copied, 2 failed"
+	Text    string `json:"text"`
+	Copied  int    `json:"copied"`
+	Skipped int    `json:"skipped"`
+	Deleted int    `json:"deleted"`
+	Failed  int    `json:"failed"`
+	// Error is the error that aborted the job
+	Error    string    `json:"error,omitempty"`
+	Sections []Section `json:"sections,omitempty"`
+}
+
+// Section is a titled list of artifacts of a summary, e.g. the failed uploads
+type Section struct {
+	Title string   `json:"title"`
+	Items []string `json:"items"`
+}
+
+// Report is what a notifier is sent at the end of a run: the summaries of its jobs
+type Report struct {
+	// BuildURL is the CI build of the run, if any
+	BuildURL string    `json:"buildUrl,omitempty"`
+	Jobs     []Summary `json:"jobs"`
+}
+
+// Summarize returns the summary of a run of the job, and false if there is nothing to report: a replication without failures.
+// Any error aborting the job is reported, including wrong settings found while running it, e.g. a missing prod registry
+// of a cleanup, as a config failing Config.Validate stops the process before any job runs.
+func Summarize(j config.Job, res *result.Result, err error) (Summary, bool) {
+	s := Summary{Job: j.Name, Source: j.Source.Registry, Destination: j.Destination.Registry}
+	if res != nil {
+		s.Copied, s.Skipped, s.Deleted, s.Failed = len(res.Copied), len(res.Skipped), len(res.Deleted), len(res.Failed)
+	}
+	s.Text = fmt.Sprintf("%d copied, %d skipped, %d deleted, %d failed", s.Copied, s.Skipped, s.Deleted, s.Failed)
+	switch {
+	case err != nil:
+		s.Severity, s.Error = Failure, err.Error()
+		s.Text = "aborted: " + err.Error()
+	case res == nil:
+		return s, false
+	case res.HasFailures() && j.Check:
+		s.Severity = Drift
+		s.Text = fmt.Sprintf("%d missing or different at destination", s.Failed)
+	case res.HasFailures():
+		s.Severity = Failure
+	case j.Clean.Enabled && s.Deleted != 0:
+		s.Severity = Cleanup
+		s.Text = fmt.Sprintf("%d removed, %d kept", s.Deleted, s.Skipped)
+	default:
+		return s, false
+	}
+	if res != nil {
+		s.Sections = sections(res, s.Severity == Cleanup)
+	}
+	return s, true
+}
+
+// sections groups the failed items by operation and reason, and lists the deleted ones of cleanups
+func sections(res *result.Result, deleted bool) []Section {
+	var list []Section
+	index := make(map[string]int)
+	add := func(title string, item string) {
+		i, ok := index[title]
+		if !ok {
+			i = len(list)
+			index[title] = i
+			list = append(list, Section{Title: title})
+		}
+		list[i].Items = append(list[i].Items, item)
+	}
+	res.Each(func(kind string, item result.Item) {
+		switch {
+		case kind == "failed" && item.Error != "":
+			add(capitalize(item.Op+" failed"), item.Name+": "+item.Error)
+		case kind == "failed" && item.Reason != "":
+			add(capitalize(item.Reason), item.Name)
+		case kind == "failed":
+			add(capitalize(item.Op+" failed"), item.Name)
+		case kind == "deleted" && deleted:
+			add("Removed", item.Name)
+		}
+	})
+	return list
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// Title is the report in one line, e.g. "Replication: 1 failure, 2 drift"
+func (r Report) Title() string {
+	counts := make(map[string]int)
+	for _, s := range r.Jobs {
+		counts[s.Severity]++
+	}
+	var parts []string
+	for _, severity := range []string{Failure, Drift, Cleanup} {
+		if counts[severity] != 0 {
+			parts = append(parts, strconv.Itoa(counts[severity])+" "+severity)
+		}
+	}
+	return "Replication: " + strings.Join(parts, ", ")
+}
+
+// Text is the report as plain text: the build URL, then every job with its sections
+func (r Report) Text() string {
+	var b strings.Builder
+	if r.BuildURL != "" {
+		b.WriteString(r.BuildURL + "\n")
+	}
+	for i, s := range r.Jobs {
+		if i != 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(s.Heading() + "\n")
+		for _, section := range s.Sections {
+			b.WriteString(section.Title + ":\n")
+			for _, item := range section.Items {
+				b.WriteString("  " + item + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// Heading is the summary in one line, e.g. "[failure] docker-prod, registry.example.com to ecr: 3 copied, 2 failed"
+func (s Summary) Heading() string {
+	return "[" + s.Severity + "] " + s.Job + ", " + s.Source + " to " + s.Destination + ": " + s.Text
+}
@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Teams posts the report as a message card to a Microsoft Teams incoming webhook, with a section per job
+type Teams struct {
+	Webhook string
+}
+
+type teamsSection struct {
+	ActivityTitle string `json:"activityTitle"`
+	Text          string `json:"text"`
+}
+
+func (t *Teams) Notify(r Report) error {
+	card := struct {
+		Type     string         `json:"@type"`
+		Context  string         `json:"@context"`
+		Summary  string         `json:"summary"`
+		Title    string         `json:"title"`
+		Text     string         `json:"text,omitempty"`
+		Sections []teamsSection `json:"sections"`
+	}{
+		Type:    "MessageCard",
+		Context: "https://schema.org/extensions",
+		Summary: r.Title(),
+		Title:   r.Title(),
+		Text:    r.BuildURL,
+	}
+	for _, s := range r.Jobs {
+		var lines []string
+		for _, section := range s.Sections {
+			lines = append(lines, "**"+section.Title+"**")
+			for _, item := range section.Items {
+				lines = append(lines, "- "+item)
+			}
+		}
+		// teams markdown needs a blank line between lines
+		card.Sections = append(card.Sections, teamsSection{ActivityTitle: s.Heading(), Text: strings.Join(lines, "\n\n")})
+	}
+	body, err := json.Marshal(card)
+	if err != nil {
+		return err
+	}
+	_, err = post(t.Webhook, body)
+	return err
+}
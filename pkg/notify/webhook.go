@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/tmpl"
+)
+
+// Webhook posts the report as JSON, or the payload of its template, to any URL
+type Webhook struct {
+	URL      string
+	template *template.Template
+}
+
+// NewWebhook returns a webhook posting the payload of the text/template executed with the report,
+// or the report as JSON if the template is empty
+func NewWebhook(url string, text string) (*Webhook, error) {
+	w := &Webhook{URL: url}
+	if text != "" {
+		t, err := tmpl.Parse("webhook", text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: webhook template: %v", config.ErrInvalid, err)
+		}
+		w.template = t
+	}
+	return w, nil
+}
+
+func (w *Webhook) Notify(r Report) error {
+	if w.template == nil {
+		body, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = post(w.URL, body)
+		return err
+	}
+	var body bytes.Buffer
+	err := w.template.Execute(&body, r)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(body.Bytes()) {
+		return errors.New("webhook template payload is not valid JSON: " + body.String())
+	}
+	_, err = post(w.URL, body.Bytes())
+	return err
+}
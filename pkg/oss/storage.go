@@ -0,0 +1,137 @@
+package oss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/loqutus/aliyun-oss-go-sdk/oss"
+	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// Storage stores binary artifacts in an Alibaba Cloud OSS bucket, with the destination credentials
+type Storage struct {
+	name   string
+	bucket *oss.Bucket
+}
+
+// NewStorage returns the storage of the bucket at the endpoint, e.g. oss-cn-beijing.aliyuncs.com
+func NewStorage(bucket string, creds credentials.Creds, endpoint string) (*Storage, error) {
+	ossClient, err := oss.New(endpoint, creds.DestinationUser, creds.DestinationPassword)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ossClient.Bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{name: bucket, bucket: b}, nil
+}
+
+// do calls f with retries, the object not being found is returned right away
+func do(name string, f func() error) error {
+	return retry.Do(context.Background(), name, func() error {
+		err := f()
+		if isNotFound(err) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+}
+
+func isNotFound(err error) bool {
+	serviceErr, ok := err.(oss.ServiceError)
+	return ok && serviceErr.StatusCode == http.StatusNotFound
+}
+
+// List returns the objects under the prefix, following the listing markers
+func (s *Storage) List(prefix string) ([]storage.Object, error) {
+	var objects []storage.Object
+	marker := ""
+	for {
+		var lsRes oss.ListObjectsResult
+		err := do("listing oss bucket "+s.name, func() error {
+			var err error
+			lsRes, err = s.bucket.ListObjects(oss.Prefix(prefix), oss.Marker(marker), oss.MaxKeys(1000))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range lsRes.Objects {
+			objects = append(objects, storage.Object{Key: object.Key, Size: object.Size, Modified: object.LastModified})
+		}
+		if !lsRes.IsTruncated {
+			return objects, nil
+		}
+		marker = lsRes.NextMarker
+	}
+}
+
+// Stat returns the object with the sha256 stored in its metadata by Put
+func (s *Storage) Stat(key string) (storage.Object, error) {
+	var header http.Header
+	err := do("getting oss object "+s.name+"/"+key, func() error {
+		var err error
+		header, err = s.bucket.GetObjectDetailedMeta(key)
+		return err
+	})
+	if isNotFound(err) {
+		return storage.Object{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Object{}, err
+	}
+	size, _ := strconv.ParseInt(header.Get(oss.HTTPHeaderContentLength), 10, 64)
+	modified, _ := http.ParseTime(header.Get(oss.HTTPHeaderLastModified))
+	return storage.Object{
+		Key:      key,
+		Size:     size,
+		Modified: modified,
+		SHA256:   header.Get(oss.HTTPHeaderOssMetaPrefix + "Sha256"),
+	}, nil
+}
+
+// SHA256 returns the checksum stored in the object metadata by Put
+func (s *Storage) SHA256(key string) (string, error) {
+	o, err := s.Stat(key)
+	if err != nil {
+		return "", err
+	}
+	if o.SHA256 == "" {
+		return "", fmt.Errorf("%w in metadata of %s/%s", storage.ErrNoChecksum, s.name, key)
+	}
+	return o.SHA256, nil
+}
+
+// Get downloads the object to the file path
+func (s *Storage) Get(key string, path string) error {
+	return do("downloading "+key+" from oss bucket "+s.name, func() error {
+		return s.bucket.GetObjectToFile(key, path)
+	})
+}
+
+// Put uploads the file, storing its sha256 in the object metadata
+func (s *Storage) Put(key string, path string) error {
+	fileSHA256, err := sha256.ComputeFileSHA256(path)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Uploading", "destination", s.name, "file", key, "sha256", fileSHA256)
+	return do("uploading "+key+" to oss bucket "+s.name, func() error {
+		return s.bucket.PutObjectFromFile(key, path, oss.Meta("sha256", fileSHA256))
+	})
+}
+
+// Delete removes the object
+func (s *Storage) Delete(key string) error {
+	logger.Info("Removing", "destination", s.name, "file", key)
+	return do("removing "+key+" from oss bucket "+s.name, func() error {
+		return s.bucket.DeleteObject(key)
+	})
+}
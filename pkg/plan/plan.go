@@ -0,0 +1,143 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// action operations
+const (
+	OpCopy      = "copy"
+	OpOverwrite = "overwrite"
+	OpDelete    = "delete"
+	OpSkip      = "skip"
+	// OpError marks an artifact which couldn't be planned, e.g. when comparing digests failed
+	OpError = "error"
+)
+
+// Action is one planned change of a job destination, or a skipped artifact with the reason
+type Action struct {
+	Job    string `json:"job"`
+	Op     string `json:"op"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	// Repo and Ref locate the artifact: the docker repo and tag, or the binary source directory and file path.
+	// For binary deletes Ref is the destination key.
+	Repo string `json:"repo,omitempty"`
+	Ref  string `json:"ref,omitempty"`
+	// Forced is set on binary copies of files already present at destination, or always synced
+	Forced bool `json:"forced,omitempty"`
+}
+
+// Plan is the list of actions computed for one or more jobs, which can be saved and applied later
+type Plan struct {
+	mu      sync.Mutex
+	Created time.Time `json:"created"`
+	Actions []Action  `json:"actions"`
+}
+
+// New returns an empty plan
+func New() *Plan {
+	return &Plan{Created: time.Now()}
+}
+
+// Add appends the action, it's safe for concurrent use
+func (p *Plan) Add(a Action) {
+	p.mu.Lock()
+	p.Actions = append(p.Actions, a)
+	p.mu.Unlock()
+}
+
+// Job returns the actions of the job, in a stable order
+func (p *Plan) Job(job string) []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var actions []Action
+	for _, a := range p.Actions {
+		if a.Job == job {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// Count returns the number of actions with the operation
+func (p *Plan) Count(op string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var n int
+	for _, a := range p.Actions {
+		if a.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// Sort orders the actions by job, operation and name, so plans can be compared
+func (p *Plan) Sort() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sort.SliceStable(p.Actions, func(i, j int) bool {
+		a, b := p.Actions[i], p.Actions[j]
+		if a.Job != b.Job {
+			return a.Job < b.Job
+		}
+		if a.Op != b.Op {
+			return a.Op < b.Op
+		}
+		return a.Name < b.Name
+	})
+}
+
+// WriteTable writes the actions as an aligned table followed by a summary line
+func (p *Plan) WriteTable(w io.Writer) error {
+	p.Sort()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tOP\tNAME\tREASON")
+	p.mu.Lock()
+	for _, a := range p.Actions {
+		fmt.Fprintln(tw, a.Job+"\t"+strings.ToUpper(a.Op)+"\t"+a.Name+"\t"+a.Reason)
+	}
+	p.mu.Unlock()
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n%d to copy, %d to overwrite, %d to delete, %d skipped, %d errors\n", p.Count(OpCopy), p.Count(OpOverwrite), p.Count(OpDelete), p.Count(OpSkip), p.Count(OpError))
+	return err
+}
+
+// WriteJSON writes the plan as indented JSON, the format of saved plans
+func (p *Plan) WriteJSON(w io.Writer) error {
+	p.Sort()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Load reads a plan saved with WriteJSON
+func Load(path string) (*Plan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("reading plan %s: %w", path, err)
+	}
+	return &p, nil
+}
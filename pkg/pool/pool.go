@@ -0,0 +1,121 @@
+package pool
+
+import (
+	"sync"
+)
+
+// Pool runs replication tasks concurrently, bounded by a global and a per host limit
+type Pool struct {
+	concurrency int
+	perHost     int
+	mu          sync.Mutex
+	// queue holds the submitted tasks not started yet, in order
+	queue   []task
+	running int
+	// busy is the number of running tasks touching each host
+	busy map[string]int
+	wg   sync.WaitGroup
+}
+
+type task struct {
+	hosts []string
+	run   func()
+}
+
+// New returns a pool running at most concurrency tasks at once, and at most perHost tasks touching the same host.
+// perHost 0 means only the global limit applies.
+func New(concurrency int, perHost int) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Pool{
+		concurrency: concurrency,
+		perHost:     perHost,
+		busy:        make(map[string]int),
+	}
+}
+
+// Go queues the task, run once a slot is free globally and for every host it touches. Tasks waiting for a busy host
+// don't hold a global slot, so the tasks of other hosts run meanwhile. Go never blocks, so tasks can submit more tasks,
+// e.g. when walking directories, and only the running tasks have a goroutine.
+func (p *Pool) Go(hosts []string, run func()) {
+	p.wg.Add(1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = append(p.queue, task{hosts: unique(hosts), run: run})
+	p.dispatch()
+}
+
+// Wait waits for every submitted task, including the ones submitted by other tasks
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+// dispatch starts the first queued tasks with free slots, with p.mu held
+func (p *Pool) dispatch() {
+	for p.running < p.concurrency {
+		i := p.next()
+		if i < 0 {
+			return
+		}
+		t := p.queue[i]
+		p.queue = append(p.queue[:i], p.queue[i+1:]...)
+		p.running++
+		for _, host := range t.hosts {
+			p.busy[host]++
+		}
+		go p.execute(t)
+	}
+}
+
+// next returns the index of the first queued task whose hosts are all below the per host limit, or -1
+func (p *Pool) next() int {
+	for i, t := range p.queue {
+		free := true
+		for _, host := range t.hosts {
+			if p.perHost > 0 && p.busy[host] >= p.perHost {
+				free = false
+				break
+			}
+		}
+		if free {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Pool) execute(t task) {
+	defer p.wg.Done()
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.running--
+		for _, host := range t.hosts {
+			p.busy[host]--
+			if p.busy[host] == 0 {
+				delete(p.busy, host)
+			}
+		}
+		p.dispatch()
+	}()
+	t.run()
+}
+
+// unique returns the hosts without duplicates, so that a task counts once for a host it lists twice
+func unique(hosts []string) []string {
+	var list []string
+	for _, host := range hosts {
+		found := false
+		for _, h := range list {
+			if h == host {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, host)
+		}
+	}
+	return list
+}
@@ -0,0 +1,66 @@
+package pool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBusyHost checks that tasks waiting for a busy host don't keep the tasks of other hosts from running
+func TestBusyHost(t *testing.T) {
+	p := New(2, 1)
+	release := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		p.Go([]string{"a"}, func() { <-release })
+	}
+	done := make(chan struct{})
+	p.Go([]string{"b"}, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task of host b not run while host a is busy")
+	}
+	close(release)
+	p.Wait()
+}
+
+// TestLimits checks the global and per host limits, with tasks submitting more tasks
+func TestLimits(t *testing.T) {
+	p := New(4, 2)
+	var mu sync.Mutex
+	running := 0
+	hosts := make(map[string]int)
+	count := 0
+	var run func(host string, depth int) func()
+	run = func(host string, depth int) func() {
+		return func() {
+			mu.Lock()
+			running++
+			hosts[host]++
+			if running > 4 {
+				t.Errorf("%d tasks running, expected at most 4", running)
+			}
+			if hosts[host] > 2 {
+				t.Errorf("%d tasks running on %s, expected at most 2", hosts[host], host)
+			}
+			count++
+			mu.Unlock()
+			if depth < 2 {
+				for _, h := range []string{"a", "b", "c"} {
+					p.Go([]string{h, h}, run(h, depth+1))
+				}
+			}
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			hosts[host]--
+			mu.Unlock()
+		}
+	}
+	p.Go([]string{"a"}, run("a", 0))
+	p.Wait()
+	// 1 + 3 + 9 tasks
+	if count != 13 {
+		t.Errorf("ran %d tasks, expected 13", count)
+	}
+}
@@ -1,72 +1,62 @@
 package repos
 
 import (
-	"log"
-	"os"
+	"fmt"
 
 	"github.com/loqutus/artifactory-replication/pkg/binary"
-	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/config"
 	"github.com/loqutus/artifactory-replication/pkg/docker"
-	"github.com/loqutus/artifactory-replication/pkg/slack"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/result"
 )
 
-func Check(sourceRegistry string, destinationRegistry string, creds credentials.Creds, artifactType string, destinationRegistryType string, dir string) {
-	log.Println("Checking " + destinationRegistryType + " repo consistency between " + sourceRegistry + " and " + destinationRegistry)
-	var slackMessage string
+// Check compares the source and destination repos.
+// Missing repos, tags and files are the failed items of the returned result, reported as drift to the job notifiers.
+func Check(job config.Job) (*result.Result, error) {
+	sourceRegistry := job.Source.Registry
+	destinationRegistry := job.Destination.Registry
+	destinationRegistryType := job.Destination.Type
+	artifactType := job.Type
+	l := logger.Job(job)
+	l.Info("Checking repo consistency", "type", destinationRegistryType)
+	var res *result.Result
+	var err error
 	if artifactType == "docker" {
-		err := docker.CheckRepos(sourceRegistry, destinationRegistry, destinationRegistryType, creds)
-		if err != nil {
-			err := slack.SendMessage(err.Error())
-			if err != nil {
-				panic(err)
-			}
-		}
-		if docker.CheckFailed {
-			if len(docker.MissingRepos) > 0 {
-				log.Println("Consistency check failed, missing docker repos:")
-				slackMessage += "Consistency check failed, missing docker repos:\n"
-				for _, missingRepo := range docker.MissingRepos {
-					log.Println(missingRepo)
-					slackMessage += missingRepo + "\n"
-				}
-			}
-			if len(docker.MissingRepoTags) > 0 {
-				log.Println("Consistency check failed, missing docker tags:")
-				slackMessage += "Consistency check failed, missing docker tags:\n"
-				for _, missingRepoTag := range docker.MissingRepoTags {
-					log.Println(missingRepoTag)
-					slackMessage += missingRepoTag + "\n"
-				}
-			}
-			err := slack.SendMessage(slackMessage)
-			if err != nil {
-				panic(err)
-			}
-			os.Exit(1)
-		} else {
-			log.Println("No missing repos found")
-			return
-		}
+		res, err = docker.CheckRepos(job)
 	} else if artifactType == "binary" {
-		err := binary.CheckRepos(sourceRegistry, destinationRegistry, destinationRegistryType, creds, dir)
-		if err != nil {
-			err := slack.SendMessage(err.Error())
-			if err != nil {
-				panic(err)
-			}
+		res, err = binary.CheckRepos(job)
+	} else {
+		return nil, fmt.Errorf("%w: unknown artifact type %q", config.ErrInvalid, artifactType)
+	}
+	if err != nil {
+		return res, fmt.Errorf("checking %s against %s: %w", destinationRegistry, sourceRegistry, err)
+	}
+	if !res.HasFailures() {
+		l.Info("No missing repos found")
+		return res, nil
+	}
+	if artifactType == "docker" {
+		missingRepos := failedWithReason(res, "repo not found")
+		if len(missingRepos) > 0 {
+			l.Warn("Consistency check failed, missing docker repos", "repos", missingRepos)
+		}
+		missingRepoTags := failedWithReason(res, "tag not found")
+		if len(missingRepoTags) > 0 {
+			l.Warn("Consistency check failed, missing docker tags", "tags", missingRepoTags)
 		}
-		if binary.CheckFailed {
-			log.Println("Repo check failed, files not found in destination:")
-			log.Println(binary.CheckFailedList)
-			slackMessage += "Repo check failed, files not found in destination:\n"
-			for _, file := range binary.CheckFailedList {
-				slackMessage += file + "\n"
-			}
-			err := slack.SendMessage(slackMessage)
-			if err != nil {
-				panic(err)
-			}
-			os.Exit(1)
+	} else {
+		failedFiles := res.FailedNames("")
+		l.Warn("Repo check failed, files not found in destination", "files", failedFiles)
+	}
+	return res, nil
+}
+
+func failedWithReason(res *result.Result, reason string) []string {
+	var names []string
+	for _, item := range res.Failed {
+		if item.Reason == reason {
+			names = append(names, item.Name)
 		}
 	}
+	return names
 }
@@ -0,0 +1,161 @@
+package result
+
+import (
+	"sync"
+	"time"
+)
+
+// Item is one artifact handled by a run: a docker repo:tag, a repo, or a file path
+type Item struct {
+	Name string `json:"name"`
+	// Op is the operation the item went through or failed at, e.g. pull, push, upload, delete, check
+	Op string `json:"op,omitempty"`
+	// Reason explains why the item was copied, skipped or failed
+	Reason string `json:"reason,omitempty"`
+	// Forced is set for binaries copied again even though they were present at destination
+	Forced   bool          `json:"forced,omitempty"`
+	Bytes    int64         `json:"bytes,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Result of a replicate, clean or check run. It is safe for concurrent use.
+type Result struct {
+	mu       sync.Mutex
+	Copied   []Item        `json:"copied"`
+	Skipped  []Item        `json:"skipped"`
+	Deleted  []Item        `json:"deleted"`
+	Failed   []Item        `json:"failed"`
+	Bytes    int64         `json:"bytes"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+}
+
+// New returns an empty result started now
+func New() *Result {
+	return &Result{Started: time.Now()}
+}
+
+func (r *Result) AddCopied(item Item) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Copied = append(r.Copied, item)
+	r.Bytes += item.Bytes
+}
+
+func (r *Result) AddSkipped(name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, Item{Name: name, Reason: reason})
+}
+
+func (r *Result) AddDeleted(item Item) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Deleted = append(r.Deleted, item)
+}
+
+func (r *Result) AddFailed(name string, op string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item := Item{Name: name, Op: op, Err: err}
+	if err != nil {
+		item.Error = err.Error()
+	}
+	r.Failed = append(r.Failed, item)
+}
+
+// AddMissing records a check failure for an item missing or different at destination
+func (r *Result) AddMissing(name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failed = append(r.Failed, Item{Name: name, Op: "check", Reason: reason})
+}
+
+// Finish sets the run duration
+func (r *Result) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Duration = time.Since(r.Started)
+}
+
+// Merge appends the items of other to r
+func (r *Result) Merge(other *Result) {
+	if other == nil {
+		return
+	}
+	other.mu.Lock()
+	copied := append([]Item(nil), other.Copied...)
+	skipped := append([]Item(nil), other.Skipped...)
+	deleted := append([]Item(nil), other.Deleted...)
+	failed := append([]Item(nil), other.Failed...)
+	bytes := other.Bytes
+	other.mu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Copied = append(r.Copied, copied...)
+	r.Skipped = append(r.Skipped, skipped...)
+	r.Deleted = append(r.Deleted, deleted...)
+	r.Failed = append(r.Failed, failed...)
+	r.Bytes += bytes
+}
+
+func (r *Result) HasFailures() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Failed) != 0
+}
+
+// FailedNames returns the names of the items failed at op, or of all failed items if op is empty
+func (r *Result) FailedNames(op string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, item := range r.Failed {
+		if op == "" || item.Op == op {
+			names = append(names, item.Name)
+		}
+	}
+	return names
+}
+
+// CopiedNames returns the names of the copied items, either forced or not
+func (r *Result) CopiedNames(forced bool) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, item := range r.Copied {
+		if item.Forced == forced {
+			names = append(names, item.Name)
+		}
+	}
+	return names
+}
+
+// Each calls f with every item and its kind: copied, skipped, deleted or failed
+func (r *Result) Each(f func(kind string, item Item)) {
+	r.mu.Lock()
+	kinds := []struct {
+		kind  string
+		items []Item
+	}{
+		{"copied", append([]Item(nil), r.Copied...)},
+		{"skipped", append([]Item(nil), r.Skipped...)},
+		{"deleted", append([]Item(nil), r.Deleted...)},
+		{"failed", append([]Item(nil), r.Failed...)},
+	}
+	r.mu.Unlock()
+	for _, k := range kinds {
+		for _, item := range k.items {
+			f(k.kind, item)
+		}
+	}
+}
+
+// TotalBytes returns the bytes of the copied items
+func (r *Result) TotalBytes() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Bytes
+}
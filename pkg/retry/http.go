@@ -0,0 +1,157 @@
+package retry
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter caps the Retry-After delay asked by servers
+const maxRetryAfter = 5 * time.Minute
+
+// RetryableStatus tells whether a response status is worth retrying: too many requests and server errors
+func RetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented)
+}
+
+// HTTP sends the request with the default policy
+func HTTP(client *http.Client, req *http.Request) (*http.Response, error) {
+	return Default.HTTP(client, req)
+}
+
+// HTTP sends the request until it gets a response with a non retryable status, honoring Retry-After,
+// and returns the last response whatever its status, for the caller to check. Network errors are retried too.
+// The context of the request cancels the retries. The body is read again from GetBody for every attempt,
+// which http.NewRequest sets for in-memory bodies and NewFileRequest for files;
+// requests with a body and without GetBody are sent once.
+func (p Policy) HTTP(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	name := "HTTP " + req.Method + " " + redact(req.URL)
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	var resp *http.Response
+	attempts := 0
+	err := p.Do(ctx, name, func() error {
+		attempts++
+		attempt := req
+		if attempts > 1 {
+			if resp != nil {
+				drain(resp.Body)
+				resp = nil
+			}
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return Permanent(err)
+				}
+				attempt = req.Clone(ctx)
+				attempt.Body = body
+			}
+		}
+		r, err := client.Do(attempt)
+		if err != nil {
+			if !rewindable {
+				return Permanent(err)
+			}
+			return err
+		}
+		resp = r
+		if !RetryableStatus(r.StatusCode) || !rewindable {
+			return nil
+		}
+		return statusError(r, NewHTTPError(r.StatusCode, r.Status))
+	})
+	if resp == nil {
+		return nil, err
+	}
+	if err != nil && ctx.Err() != nil {
+		drain(resp.Body)
+		return nil, err
+	}
+	// the last attempt got a retryable status
+	return resp, nil
+}
+
+// HTTPError is the error of a response with an unexpected status, which errors.As finds to tell e.g. auth failures apart
+type HTTPError struct {
+	StatusCode int
+	msg        string
+}
+
+func (e *HTTPError) Error() string { return e.msg }
+
+// NewHTTPError returns the error of a response with the status code, e.g. "GET url returned 404 Not Found"
+func NewHTTPError(statusCode int, msg string) error {
+	return &HTTPError{StatusCode: statusCode, msg: msg}
+}
+
+// StatusError drains and closes the body of a response with a retryable status
+// and returns the error to retry it, after its Retry-After delay if the server asked for one
+func StatusError(resp *http.Response) error {
+	drain(resp.Body)
+	return statusError(resp, NewHTTPError(resp.StatusCode, resp.Request.Method+" "+redact(resp.Request.URL)+": "+resp.Status))
+}
+
+func statusError(resp *http.Response, err error) error {
+	if delay := retryAfter(resp.Header.Get("Retry-After")); delay > 0 {
+		return After(err, delay)
+	}
+	return err
+}
+
+// NewFileRequest returns a request with the file as body, which HTTP can send again
+func NewFileRequest(method string, url string, path string) (*http.Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	req.ContentLength = info.Size()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+	return req, nil
+}
+
+// drain reads the rest of the body, so that the connection can be reused, and closes it
+func drain(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, 64*1024))
+	body.Close()
+}
+
+// retryAfter parses a Retry-After header, seconds or an HTTP date, 0 if there is none
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(header); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(header); err == nil {
+		delay = time.Until(t)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return delay
+}
+
+// redact returns the URL without its query and user info, which may hold tokens
+func redact(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.Path
+}
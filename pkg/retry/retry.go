@@ -0,0 +1,115 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+)
+
+// Policy is how many times and how long apart a failing call is retried
+type Policy struct {
+	// Attempts is the maximum number of calls, including the first one
+	Attempts int
+	// Initial is the delay after the first failure, multiplied by Multiplier after every next one, up to Max.
+	// Every delay is randomized between half and all of it, so that concurrent workers don't retry in step.
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+}
+
+// Default calls up to 5 times, waiting about 1s, 3s, 9s and 27s in between
+var Default = Policy{Attempts: 5, Initial: time.Second, Multiplier: 3, Max: time.Minute}
+
+// OnRetry is called before every retry, e.g. to count them
+var OnRetry = func() {}
+
+// Do calls f with the default policy
+func Do(ctx context.Context, name string, f func() error) error {
+	return Default.Do(ctx, name, f)
+}
+
+// Do calls f until it succeeds, returns a Permanent error, the attempts are exhausted or ctx is done,
+// and returns the last error. name describes the call in the retry logs.
+func (p Policy) Do(ctx context.Context, name string, f func() error) error {
+	for i := 1; ; i++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		delay := p.delay(i)
+		var after *afterError
+		if errors.As(err, &after) {
+			err, delay = after.err, after.delay
+		}
+		if i >= p.Attempts || ctx.Err() != nil {
+			return err
+		}
+		logger.Warn("Error "+name+", retrying", "err", err, "attempt", i, "delay", delay.Round(time.Millisecond))
+		err = sleep(ctx, delay, err)
+		if err != nil {
+			return err
+		}
+		OnRetry()
+	}
+}
+
+// delay returns the randomized delay after the attempt
+func (p Policy) delay(attempt int) time.Duration {
+	d := float64(p.Initial) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.Max) || math.IsInf(d, 0) {
+		d = float64(p.Max)
+	}
+	return time.Duration(d/2 + rand.Float64()*d/2)
+}
+
+// sleep waits for the delay, or returns the last error of the call with the ctx error if ctx is done first
+func sleep(ctx context.Context, delay time.Duration, last error) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%v: %w", last, ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error that retrying won't fix, e.g. a missing file, to return it right away
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type afterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *afterError) Error() string { return e.err.Error() }
+func (e *afterError) Unwrap() error { return e.err }
+
+// After wraps an error to retry after the delay instead of the policy one, e.g. the Retry-After of a response
+func After(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &afterError{err: err, delay: delay}
+}
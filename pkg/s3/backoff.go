@@ -1,5 +0,0 @@
-package s3
-
-var backOffStart = 1000
-var backOffMultiplier = 3
-var backOffSteps = 5
@@ -1,49 +1,34 @@
 package s3
 
 import (
-	"log"
+	"context"
 	"os"
-	"strings"
-
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 )
 
-func Download(bucket string, objectPath string, dir string) (string, error) {
-	sess := session.Must(session.NewSession())
+// Get downloads the object to the file path
+func (s *Storage) Get(key string, path string) error {
+	sess, err := session.NewSession(&aws.Config{HTTPClient: httpclient.Client()})
+	if err != nil {
+		return err
+	}
 	downloader := s3manager.NewDownloader(sess)
-	s := strings.Split(objectPath, "/")
-	fileName := s[len(s)-1]
-	f, err := os.Create(dir + string('/') + fileName)
+	f, err := os.Create(path)
 	if err != nil {
-		return "", err
+		return err
 	}
-	var failed bool
-	backOffTime := backOffStart
 	defer f.Close()
-	for i := 1; i <= backOffSteps; i++ {
+	return retry.Do(context.Background(), "downloading "+key+" from s3 bucket "+s.Bucket, func() error {
 		_, err := downloader.Download(f, &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(objectPath),
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
 		})
-		if err != nil {
-			failed = true
-			log.Println("error downloader.Download:", err.Error())
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			failed = false
-			break
-		}
-	}
-	if failed == true {
-		return "", err
-	}
-	return fileName, nil
+		return classify(err)
+	})
 }
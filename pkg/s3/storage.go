@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+)
+
+// Storage stores binary artifacts in an S3 bucket, using the AWS credentials of the environment
+type Storage struct {
+	Bucket string
+}
+
+// NewStorage returns the storage of the bucket
+func NewStorage(bucket string) *Storage {
+	return &Storage{Bucket: bucket}
+}
+
+func (s *Storage) client() (*s3.S3, error) {
+	sess, err := session.NewSession(&aws.Config{HTTPClient: httpclient.Client()})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// List returns the objects under the prefix, following the listing pages
+func (s *Storage) List(prefix string) ([]storage.Object, error) {
+	svc, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	var objects []storage.Object
+	err = retry.Do(context.Background(), "listing s3 bucket "+s.Bucket, func() error {
+		objects = nil
+		err := svc.ListObjectsPages(&s3.ListObjectsInput{Bucket: aws.String(s.Bucket), Prefix: aws.String(prefix)},
+			func(p *s3.ListObjectsOutput, last bool) (shouldContinue bool) {
+				for _, obj := range p.Contents {
+					objects = append(objects, storage.Object{
+						Key:      aws.StringValue(obj.Key),
+						Size:     aws.Int64Value(obj.Size),
+						Modified: aws.TimeValue(obj.LastModified),
+					})
+				}
+				return true
+			})
+		return classify(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Stat returns the object with the sha256 stored in its metadata by Put
+func (s *Storage) Stat(key string) (storage.Object, error) {
+	svc, err := s.client()
+	if err != nil {
+		return storage.Object{}, err
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	var object *s3.HeadObjectOutput
+	err = retry.Do(context.Background(), "getting s3 object "+s.Bucket+"/"+key, func() error {
+		var err error
+		object, err = svc.HeadObject(input)
+		return classify(err)
+	})
+	if isNotFound(err) {
+		return storage.Object{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Object{}, err
+	}
+	o := storage.Object{
+		Key:      key,
+		Size:     aws.Int64Value(object.ContentLength),
+		Modified: aws.TimeValue(object.LastModified),
+	}
+	// the SDK canonicalizes metadata keys
+	for k, v := range object.Metadata {
+		if k == "Sha256" || k == "sha256" {
+			o.SHA256 = aws.StringValue(v)
+		}
+	}
+	return o, nil
+}
+
+// SHA256 returns the checksum stored in the object metadata by Put
+func (s *Storage) SHA256(key string) (string, error) {
+	o, err := s.Stat(key)
+	if err != nil {
+		return "", err
+	}
+	if o.SHA256 == "" {
+		return "", fmt.Errorf("%w in metadata of %s/%s", storage.ErrNoChecksum, s.Bucket, key)
+	}
+	return o.SHA256, nil
+}
+
+// classify marks the client errors of a request, but throttling, as permanent for retry.Do
+func classify(err error) error {
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		code := aerr.StatusCode()
+		if code >= 400 && code < 500 && code != http.StatusTooManyRequests {
+			return retry.Permanent(err)
+		}
+	}
+	return err
+}
+
+func isNotFound(err error) bool {
+	aerr, ok := err.(awserr.RequestFailure)
+	return ok && aerr.StatusCode() == http.StatusNotFound
+}
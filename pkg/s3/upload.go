@@ -1,22 +1,27 @@
 package s3
 
 import (
-	"log"
+	"context"
+	"io"
 	"os"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
 	"github.com/loqutus/artifactory-replication/pkg/sha256"
 )
 
-func Upload(destinationRegistry string, destinationFileName string, tempFileName string) error {
-	f, err := os.Open(tempFileName)
+// Put uploads the file, storing its sha256 in the object metadata
+func (s *Storage) Put(key string, path string) error {
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	sess, err := session.NewSession(&aws.Config{})
+	defer f.Close()
+	sess, err := session.NewSession(&aws.Config{HTTPClient: httpclient.Client()})
 	if err != nil {
 		return err
 	}
@@ -24,36 +29,23 @@ func Upload(destinationRegistry string, destinationFileName string, tempFileName
 		u.PartSize = 5 * 1024 * 1024 // The minimum/default allowed part size is 5MB
 		u.Concurrency = 2            // default is 5
 	})
-	fileSHA256, err := sha256.ComputeFileSHA256(tempFileName)
+	fileSHA256, err := sha256.ComputeFileSHA256(path)
 	if err != nil {
 		return err
 	}
-	log.Println("Uploading "+destinationFileName+" to "+destinationRegistry, "SHA256:", fileSHA256)
-	backOffTime := backOffStart
-	var failed bool
-	for i := 1; i <= backOffSteps; i++ {
+	logger.Debug("Uploading", "destination", s.Bucket, "file", key, "sha256", fileSHA256)
+	return retry.Do(context.Background(), "uploading "+key+" to s3 bucket "+s.Bucket, func() error {
+		_, err := f.Seek(0, io.SeekStart)
+		if err != nil {
+			return retry.Permanent(err)
+		}
 		_, err = uploader.Upload(&s3manager.UploadInput{
-			Bucket: aws.String(destinationRegistry),
-			Key:    aws.String(destinationFileName),
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
 			Body:   f,
 			Metadata: map[string]*string{
 				"sha256": aws.String(fileSHA256),
 			}})
-		if err != nil {
-			failed = true
-			log.Print("error s3 upload", destinationFileName, "retry", string(i))
-			if i != backOffSteps {
-				time.Sleep(time.Duration(backOffTime) * time.Millisecond)
-			}
-			backOffTime *= i
-		} else {
-			failed = false
-			break
-		}
-	}
-	if failed == true {
-		return err
-	} else {
-		return nil
-	}
+		return classify(err)
+	})
 }
@@ -0,0 +1,603 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/cron"
+	"github.com/loqutus/artifactory-replication/pkg/job"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/metrics"
+	"github.com/loqutus/artifactory-replication/pkg/plan"
+	"github.com/loqutus/artifactory-replication/pkg/result"
+)
+
+// keepRuns is how many finished runs are kept for the API, the oldest are dropped first
+const keepRuns = 100
+
+// maxEvents is how many events of a run are kept, the oldest are dropped first
+const maxEvents = 10000
+
+// maxPending is how many runs of a job are queued by Enqueue, more are merged into one run of the whole job
+const maxPending = 100
+
+var (
+	// ErrUnknownJob is returned for a job name not in the config
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrRunning is returned when the job is running already
+	ErrRunning = errors.New("job running already")
+	// ErrStopped is returned once the scheduler is stopping
+	ErrStopped = errors.New("shutting down")
+)
+
+// kinds of runs
+const (
+	// KindRun runs the job as configured
+	KindRun = "run"
+	// KindCheck compares source and destination instead of replicating
+	KindCheck = "check"
+	// KindClean cleans the destination of the job
+	KindClean = "clean"
+)
+
+// Scheduler runs the jobs of a config on their schedules and on request, until stopped, never running a job twice at once
+type Scheduler struct {
+	config *config.Config
+	// AfterRun is called after every run, e.g. to push the metrics
+	AfterRun func(o job.Outcome)
+
+	mu      sync.Mutex
+	states  map[string]*state
+	runs    []*run
+	lastID  int
+	stopped bool
+	random  *rand.Rand
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Status is the state of a job, with the outcome of its last run
+type Status struct {
+	Job      string     `json:"job"`
+	Schedule string     `json:"schedule,omitempty"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"nextRun,omitempty"`
+	// CurrentRun is the ID of the running run
+	CurrentRun int `json:"currentRun,omitempty"`
+	// Pending counts the runs queued by events
+	Pending int `json:"pending"`
+	// Runs counts the runs started, Overlaps the runs refused because the previous one was still running
+	Runs     int `json:"runs"`
+	Overlaps int `json:"overlaps"`
+	// LastRun is the last finished run, without its result
+	LastRun *Run `json:"lastRun,omitempty"`
+}
+
+// Request is what to run of a job, optionally narrowed to one repo, path prefix or tag
+type Request struct {
+	// Kind is run, the default, check or clean
+	Kind string `json:"kind"`
+	// Filter replaces the docker repo prefix of the job. Binary runs only list the files under it, a path under the filter
+	// or prod filter of the job, while binary checks and cleanups use it as their filter.
+	Filter string `json:"filter,omitempty"`
+	// Repo narrows a docker run to exactly this repo
+	Repo string `json:"repo,omitempty"`
+	// Tag replaces the docker tag of the job
+	Tag string `json:"tag,omitempty"`
+}
+
+// Run is a run of a job, running or finished
+type Run struct {
+	ID  int    `json:"id"`
+	Job string `json:"job"`
+	// Trigger is schedule, api or event
+	Trigger string `json:"trigger"`
+	Request
+	// Status is running, success, failed when some artifacts failed or the check found differences, or error when the run was aborted
+	Status   string     `json:"status"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Copied   int        `json:"copied"`
+	Skipped  int        `json:"skipped"`
+	Deleted  int        `json:"deleted"`
+	Failed   int        `json:"failed"`
+	Bytes    int64      `json:"bytes"`
+	// Result lists every artifact of a finished run
+	Result *result.Result `json:"result,omitempty"`
+}
+
+// Event is a step of a run: start and end with the run, plan with the number of planned actions by operation,
+// and log with every message of the job
+type Event struct {
+	ID   int         `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type state struct {
+	job      config.Job
+	schedule cron.Schedule
+	status   Status
+	current  *run
+	// pending are the runs queued by Enqueue, started one after another by a worker while working is set
+	pending []Request
+	working bool
+}
+
+type run struct {
+	Run
+	// events are the last events, first is the ID of events[0]
+	events []Event
+	first  int
+	// changed is closed when an event is added, then replaced, or when the run is done
+	changed chan struct{}
+	done    bool
+}
+
+// New returns a scheduler of the jobs of the config, which must be valid
+func New(c *config.Config) *Scheduler {
+	s := &Scheduler{
+		config: c,
+		states: make(map[string]*state),
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:   make(chan struct{}),
+	}
+	for _, j := range c.Jobs {
+		st := &state{job: j, status: Status{Job: j.Name, Schedule: j.Schedule}}
+		if j.Schedule != "" {
+			// checked by the config validation
+			st.schedule, _ = cron.Parse(j.Schedule)
+		}
+		s.states[j.Name] = st
+	}
+	logger.Hook(s.logEvent)
+	return s
+}
+
+// Start schedules every job with a schedule, returning the number of scheduled jobs
+func (s *Scheduler) Start() int {
+	scheduled := 0
+	for _, j := range s.config.Jobs {
+		st := s.states[j.Name]
+		if st.schedule == nil {
+			continue
+		}
+		scheduled++
+		s.wg.Add(1)
+		go s.loop(st)
+	}
+	return scheduled
+}
+
+// Stop stops scheduling and accepting runs, and waits for the running ones to finish
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	close(s.stop)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// loop runs the job at every time of its schedule, until the scheduler is stopped.
+// The next time is computed once a run is done, so the times passed during a long run are skipped.
+func (s *Scheduler) loop(st *state) {
+	defer s.wg.Done()
+	l := logger.Job(st.job)
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		next := st.schedule.Next(time.Now())
+		if next.IsZero() {
+			l.Warn("Schedule has no next run, job not scheduled anymore", "schedule", st.job.Schedule)
+			return
+		}
+		next = next.Add(s.jitter(st.job.Jitter))
+		s.mu.Lock()
+		st.status.NextRun = &next
+		s.mu.Unlock()
+		l.Info("Next run scheduled", "at", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		r, err := s.start(st, Request{Kind: KindRun}, "schedule")
+		if err != nil {
+			l.Warn("Scheduled run skipped", "err", err)
+			continue
+		}
+		s.execute(st, st.job, r)
+	}
+}
+
+// jitter returns a random duration up to max
+func (s *Scheduler) jitter(max config.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.random.Int63n(int64(max)))
+}
+
+// Trigger starts a run of the job, returning it right away
+func (s *Scheduler) Trigger(name string, req Request) (Run, error) {
+	st, ok := s.states[name]
+	if !ok {
+		return Run{}, fmt.Errorf("%w %s", ErrUnknownJob, name)
+	}
+	j, err := req.job(st.job)
+	if err != nil {
+		return Run{}, err
+	}
+	r, err := s.start(st, req, "api")
+	if err != nil {
+		return Run{}, err
+	}
+	logger.Job(j).Info("Run requested", "run", r.ID, "kind", r.Kind, "filter", r.Filter, "repo", r.Repo, "tag", r.Tag)
+	go s.execute(st, j, r)
+	return r.Run, nil
+}
+
+// Enqueue queues a run of the job, e.g. for an artifact pushed to the source, started once the job isn't running.
+// A run already queued isn't queued twice. The queued runs are dropped when the scheduler stops.
+func (s *Scheduler) Enqueue(name string, req Request) error {
+	st, ok := s.states[name]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownJob, name)
+	}
+	_, err := req.job(st.job)
+	if err != nil {
+		return err
+	}
+	if req.Kind == "" {
+		req.Kind = KindRun
+	}
+	whole := Request{Kind: req.Kind}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return ErrStopped
+	}
+	for _, p := range st.pending {
+		if p == req || p == whole {
+			return nil
+		}
+	}
+	switch {
+	case req == whole:
+		st.pending = []Request{whole}
+	case len(st.pending) >= maxPending:
+		st.pending = []Request{whole}
+	default:
+		st.pending = append(st.pending, req)
+	}
+	if !st.working {
+		st.working = true
+		s.wg.Add(1)
+		go s.work(st)
+	}
+	return nil
+}
+
+// work runs the queued runs of the job one after another, waiting for the running one to finish
+func (s *Scheduler) work(st *state) {
+	defer s.wg.Done()
+	for {
+		s.waitIdle(st)
+		s.mu.Lock()
+		if s.stopped || len(st.pending) == 0 {
+			dropped := len(st.pending)
+			st.pending = nil
+			st.working = false
+			s.mu.Unlock()
+			if dropped != 0 {
+				logger.Job(st.job).Warn("Queued runs dropped", "count", dropped)
+			}
+			return
+		}
+		req := st.pending[0]
+		st.pending = st.pending[1:]
+		s.mu.Unlock()
+		// checked by Enqueue
+		j, _ := req.job(st.job)
+		r, err := s.start(st, req, "event")
+		if errors.Is(err, ErrRunning) {
+			s.mu.Lock()
+			st.pending = append([]Request{req}, st.pending...)
+			s.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		s.execute(st, j, r)
+	}
+}
+
+// waitIdle waits until the job isn't running, or the scheduler is stopping
+func (s *Scheduler) waitIdle(st *state) {
+	for {
+		s.mu.Lock()
+		var changed chan struct{}
+		if st.current != nil {
+			changed = st.current.changed
+		}
+		s.mu.Unlock()
+		if changed == nil {
+			return
+		}
+		select {
+		case <-changed:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Plan returns what a run of the job would copy, overwrite, delete or skip, without changing the destination
+func (s *Scheduler) Plan(name string, req Request) (*plan.Plan, error) {
+	st, ok := s.states[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownJob, name)
+	}
+	j, err := req.job(st.job)
+	if err != nil {
+		return nil, err
+	}
+	p := plan.New()
+	err = job.Plan(j, p)
+	if err != nil {
+		return nil, fmt.Errorf("job %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// job returns the job changed by the request
+func (req Request) job(j config.Job) (config.Job, error) {
+	switch req.Kind {
+	case "", KindRun:
+	case KindCheck:
+		j.Check = true
+	case KindClean:
+		j.Check = false
+		j.Clean.Enabled = true
+	default:
+		return j, fmt.Errorf("%w: unknown run kind %q, expected run, check or clean", config.ErrInvalid, req.Kind)
+	}
+	if req.Filter != "" {
+		if j.Type == "binary" && !j.Check && !j.Clean.Enabled {
+			// the filters stay, the helm index of their repos is regenerated with the files copied
+			narrow := strings.Trim(req.Filter, "/")
+			if !under(narrow, j.Filter) && !under(narrow, j.FilterProd) {
+				return j, fmt.Errorf("%w: filter %s not under the filter or prod filter of the job", config.ErrInvalid, req.Filter)
+			}
+			j.Narrow = narrow
+		} else {
+			j.Filter = req.Filter
+		}
+	}
+	if req.Repo != "" {
+		if j.Type != "docker" {
+			return j, fmt.Errorf("%w: repo of a %s job", config.ErrInvalid, j.Type)
+		}
+		j.Docker.Repo = req.Repo
+	}
+	if req.Tag != "" {
+		if j.Type != "docker" {
+			return j, fmt.Errorf("%w: tag of a %s job", config.ErrInvalid, j.Type)
+		}
+		j.Docker.Tag = req.Tag
+	}
+	return j, j.Validate()
+}
+
+// under tells whether the path is the filter or under it
+func under(path string, filter string) bool {
+	filter = strings.Trim(filter, "/")
+	return filter != "" && (path == filter || strings.HasPrefix(path, filter+"/"))
+}
+
+// start records a new run of the job, unless it is running already or the scheduler is stopping.
+// Stop waits for the run until execute is done with it.
+func (s *Scheduler) start(st *state, req Request, trigger string) (*run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil, ErrStopped
+	}
+	if st.current != nil {
+		if trigger != "event" {
+			st.status.Overlaps++
+		}
+		return nil, fmt.Errorf("%w: run %d", ErrRunning, st.current.ID)
+	}
+	if req.Kind == "" {
+		req.Kind = KindRun
+	}
+	s.lastID++
+	r := &run{
+		Run:     Run{ID: s.lastID, Job: st.job.Name, Trigger: trigger, Request: req, Status: "running", Started: time.Now()},
+		first:   1,
+		changed: make(chan struct{}),
+	}
+	st.current = r
+	st.status.Running = true
+	st.status.CurrentRun = r.ID
+	st.status.Runs++
+	if trigger == "schedule" {
+		st.status.NextRun = nil
+	}
+	s.wg.Add(1)
+	s.runs = append(s.runs, r)
+	for len(s.runs) > keepRuns && s.runs[0].done {
+		s.runs = s.runs[1:]
+	}
+	r.emit("start", r.Run)
+	return r, nil
+}
+
+// execute runs the job and records its outcome in the run
+func (s *Scheduler) execute(st *state, j config.Job, r *run) {
+	defer s.wg.Done()
+	res, err := job.RunPlanned(j, func(actions []plan.Action) {
+		counts := map[string]int{"total": len(actions)}
+		for _, a := range actions {
+			counts[a.Op]++
+		}
+		s.mu.Lock()
+		r.emit("plan", counts)
+		s.mu.Unlock()
+	})
+	metrics.RecordJob(j.Name, res, err)
+	outcome := job.Outcome{Job: j.Name, Result: res, Err: err}
+	// the job of the request, e.g. a check is reported as drift
+	job.Notify(&config.Config{Jobs: []config.Job{j}}, []job.Outcome{outcome})
+	if err != nil {
+		logger.Job(j).Error(err.Error(), "run", r.ID)
+	}
+
+	s.mu.Lock()
+	finished := time.Now()
+	r.Finished = &finished
+	r.Status = "success"
+	if res != nil {
+		r.Copied, r.Skipped, r.Deleted, r.Failed = len(res.Copied), len(res.Skipped), len(res.Deleted), len(res.Failed)
+		r.Bytes = res.TotalBytes()
+		r.Result = res
+		if res.HasFailures() {
+			r.Status = "failed"
+		}
+	}
+	if err != nil {
+		r.Status, r.Error = "error", err.Error()
+	}
+	last := r.Run
+	last.Result = nil
+	st.current = nil
+	st.status.Running = false
+	st.status.CurrentRun = 0
+	st.status.LastRun = &last
+	r.emit("end", r.Run)
+	r.done = true
+	s.mu.Unlock()
+	if s.AfterRun != nil {
+		s.AfterRun(outcome)
+	}
+}
+
+// emit adds an event to the run and wakes up its watchers, s.mu must be held
+func (r *run) emit(typ string, data interface{}) {
+	r.events = append(r.events, Event{ID: r.first + len(r.events), Type: typ, Data: data})
+	if drop := len(r.events) - maxEvents; drop > 0 {
+		r.events = append([]Event(nil), r.events[drop:]...)
+		r.first += drop
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// logEvent adds the messages with a job field to the running run of the job
+func (s *Scheduler) logEvent(e logger.Entry) {
+	name, ok := e.Fields["job"].(string)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.states[name]
+	if !ok || st.current == nil {
+		return
+	}
+	st.current.emit("log", e)
+}
+
+// Status returns the state of every job, in the order of the config
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Status, 0, len(s.config.Jobs))
+	for _, j := range s.config.Jobs {
+		st := s.states[j.Name]
+		status := st.status
+		status.Pending = len(st.pending)
+		list = append(list, status)
+	}
+	return list
+}
+
+// Runs returns the recent runs of the job, or of every job if name is empty, the last one first
+func (s *Scheduler) Runs(name string) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Run{}
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if name == "" || s.runs[i].Job == name {
+			list = append(list, s.runs[i].Run)
+		}
+	}
+	return list
+}
+
+// FindRun returns the run with the ID, if it is still kept
+func (s *Scheduler) FindRun(id int) (Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.find(id)
+	if r == nil {
+		return Run{}, false
+	}
+	return r.Run, true
+}
+
+func (s *Scheduler) find(id int) *run {
+	for _, r := range s.runs {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// Events returns the events of the run from the ID from, whether the run is done,
+// and a channel closed when more events are added. ok is false if the run isn't kept.
+func (s *Scheduler) Events(id int, from int) (events []Event, done bool, changed <-chan struct{}, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.find(id)
+	if r == nil {
+		return nil, false, nil, false
+	}
+	i := from - r.first
+	if i < 0 {
+		i = 0
+	}
+	if i < len(r.events) {
+		events = append(events, r.events[i:]...)
+	}
+	return events, r.done, r.changed, true
+}
+
+// ServeHTTP serves the state of every job as JSON
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := json.MarshalIndent(s.Status(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
@@ -0,0 +1,123 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/sshkey"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// conn is an SSH connection with its SFTP session
+type conn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+func (c *conn) Close() {
+	c.sftp.Close()
+	c.ssh.Close()
+}
+
+// conns caches the connections by user and address, as a storage is created for every uploaded file
+var conns = struct {
+	sync.Mutex
+	m map[string]*conn
+}{m: make(map[string]*conn)}
+
+// withClient calls f with a cached SFTP client, dialing it if needed. On errors other than SFTP status ones,
+// e.g. a lost connection, the connection is dropped and f is retried on a new one.
+func (s *Storage) withClient(f func(c *sftp.Client) error) error {
+	id := s.User + "@" + s.Address
+	return retry.Do(context.Background(), "calling sftp "+id, func() error {
+		c, err := s.conn(id)
+		if err != nil {
+			return err
+		}
+		err = f(c.sftp)
+		if err == nil || isStatus(err) {
+			return retry.Permanent(err)
+		}
+		conns.Lock()
+		if conns.m[id] == c {
+			delete(conns.m, id)
+		}
+		conns.Unlock()
+		c.Close()
+		return err
+	})
+}
+
+// isStatus tells whether the error was returned by the SFTP server, e.g. a missing file, rather than by the connection
+func isStatus(err error) bool {
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return true
+	}
+	var statusErr *sftp.StatusError
+	return errors.As(err, &statusErr)
+}
+
+func (s *Storage) conn(id string) (*conn, error) {
+	conns.Lock()
+	defer conns.Unlock()
+	if c, ok := conns.m[id]; ok {
+		return c, nil
+	}
+	config, err := s.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Connecting to sftp", "destination", id)
+	sshClient, err := ssh.Dial("tcp", s.Address, config)
+	if err != nil {
+		return nil, err
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	c := &conn{ssh: sshClient, sftp: sftpClient}
+	conns.m[id] = c
+	return c, nil
+}
+
+// clientConfig authenticates with the private key if the password is a PEM key, with the password otherwise,
+// and verifies the host key against the known hosts file
+func (s *Storage) clientConfig() (*ssh.ClientConfig, error) {
+	var auth ssh.AuthMethod
+	if sshkey.IsPrivateKey(s.Password) {
+		signer, err := sshkey.Signer(s.Password)
+		if err != nil {
+			return nil, errors.New("sftp key of " + s.User + ": " + err.Error())
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		auth = ssh.Password(s.Password)
+	}
+	hostKeyCallback, err := sshkey.HostKeyCallback(s.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// address adds the default SSH port to the host if it has none
+func address(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "22")
+}
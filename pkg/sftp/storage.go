@@ -0,0 +1,268 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+	"github.com/pkg/sftp"
+)
+
+// The sha256 of a file is kept in sha256sum format in a hidden sidecar next to it, .<name>.sha256sum,
+// which can't be mistaken for an artifact such as index.yaml.sha256
+const (
+	sidecarPrefix = "."
+	sidecarSuffix = ".sha256sum"
+)
+
+// tempPrefix is the prefix of the files being uploaded, renamed once complete
+const tempPrefix = ".tmp-"
+
+// Storage stores binary artifacts in a directory of an SFTP server, with the same layout as the buckets
+type Storage struct {
+	// Address is the host and port of the server
+	Address string
+	// Root is the directory of the files on the server, the login directory if empty
+	Root     string
+	User     string
+	Password string
+	// KnownHosts is the known hosts file the server key is verified with
+	KnownHosts string
+}
+
+// NewStorage returns the storage of the destination, host[:port][/root/dir]. The destination password is either
+// the password or the PEM private key of the user, e.g. file:/path/id_ed25519.
+func NewStorage(destination string, creds credentials.Creds, knownHosts string) *Storage {
+	host, root := destination, ""
+	if i := strings.IndexByte(destination, '/'); i >= 0 {
+		host, root = destination[:i], destination[i:]
+	}
+	return &Storage{
+		Address:    address(host),
+		Root:       root,
+		User:       creds.DestinationUser,
+		Password:   creds.DestinationPassword,
+		KnownHosts: knownHosts,
+	}
+}
+
+// path returns the path of the key on the server. Keys are relative to Root even with a leading slash,
+// so that a file of the repo root isn't written to the root of the server.
+func (s *Storage) path(key string) string {
+	key = strings.TrimPrefix(key, "/")
+	if s.Root == "" {
+		return path.Clean(key)
+	}
+	return path.Join(s.Root, key)
+}
+
+// List returns the files under the prefix, skipping the sidecars and temp files. The sha256 isn't listed, SHA256 reads it.
+func (s *Storage) List(prefix string) ([]storage.Object, error) {
+	dir := ""
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		dir = prefix[:i]
+	}
+	var objects []storage.Object
+	err := s.withClient(func(c *sftp.Client) error {
+		objects = nil
+		start := s.path(dir)
+		walker := c.Walk(start)
+		for walker.Step() {
+			err := walker.Err()
+			if err != nil {
+				if os.IsNotExist(err) && walker.Path() == start {
+					return nil
+				}
+				return err
+			}
+			info := walker.Stat()
+			if info.IsDir() || isSidecar(info.Name()) || strings.HasPrefix(info.Name(), tempPrefix) {
+				continue
+			}
+			rel := walker.Path()
+			if start != "." {
+				rel = strings.TrimPrefix(rel, start)
+			}
+			key := strings.TrimPrefix(path.Join(dir, rel), "/")
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			objects = append(objects, storage.Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// Stat returns the file with the sha256 of its sidecar, empty if there is no sidecar
+func (s *Storage) Stat(key string) (storage.Object, error) {
+	var o storage.Object
+	err := s.withClient(func(c *sftp.Client) error {
+		info, err := c.Stat(s.path(key))
+		if err != nil {
+			return err
+		}
+		o = storage.Object{Key: key, Size: info.Size(), Modified: info.ModTime()}
+		o.SHA256, err = readSidecar(c, s.path(key))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+	if os.IsNotExist(err) {
+		return storage.Object{}, storage.ErrNotFound
+	}
+	return o, err
+}
+
+// SHA256 returns the checksum of the sidecar written by Put
+func (s *Storage) SHA256(key string) (string, error) {
+	o, err := s.Stat(key)
+	if err != nil {
+		return "", err
+	}
+	if o.SHA256 == "" {
+		return "", errors.New("missing sha256 sidecar of " + s.Address + ":" + s.path(key))
+	}
+	return o.SHA256, nil
+}
+
+// Get downloads the file to the path
+func (s *Storage) Get(key string, localPath string) error {
+	err := s.withClient(func(c *sftp.Client) error {
+		f, err := c.Open(s.path(key))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteTo(out)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+	if os.IsNotExist(err) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+// Put uploads the file to a temp file next to the destination, creating the directories, and renames it,
+// then writes the sha256 sidecar the same way, so that the partner never picks up a partial file
+func (s *Storage) Put(key string, localPath string) error {
+	fileSHA256, err := sha256.ComputeFileSHA256(localPath)
+	if err != nil {
+		return err
+	}
+	destination := s.path(key)
+	logger.Debug("Uploading", "destination", s.Address+s.Root, "file", key, "sha256", fileSHA256)
+	return s.withClient(func(c *sftp.Client) error {
+		err := c.MkdirAll(path.Dir(destination))
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		err = writeAtomic(c, destination, in)
+		if err != nil {
+			return err
+		}
+		sidecar := fileSHA256 + "  " + path.Base(destination) + "\n"
+		return writeAtomic(c, sidecarPath(destination), strings.NewReader(sidecar))
+	})
+}
+
+// Delete removes the file and its sidecar, an already removed file is not an error
+func (s *Storage) Delete(key string) error {
+	logger.Info("Removing", "destination", s.Address+s.Root, "file", key)
+	return s.withClient(func(c *sftp.Client) error {
+		for _, p := range []string{s.path(key), sidecarPath(s.path(key))} {
+			err := c.Remove(p)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeAtomic writes r to a temp file in the directory of p and renames it to p, replacing the existing file
+func writeAtomic(c *sftp.Client, p string, r io.Reader) error {
+	tempPath := path.Join(path.Dir(p), tempPrefix+path.Base(p)+"-"+strconv.Itoa(rand.Int()))
+	f, err := c.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = f.ReadFrom(r)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = rename(c, tempPath, p)
+	}
+	if err != nil {
+		c.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// rename uses the posix-rename extension of OpenSSH to replace the file atomically,
+// and falls back to removing it first on servers without it
+func rename(c *sftp.Client, oldPath string, newPath string) error {
+	err := c.PosixRename(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+	err = c.Remove(newPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.Rename(oldPath, newPath)
+}
+
+// sidecarPath returns the path of the sidecar of the file
+func sidecarPath(p string) string {
+	return path.Join(path.Dir(p), sidecarPrefix+path.Base(p)+sidecarSuffix)
+}
+
+// isSidecar tells whether the file name is the one of a sidecar
+func isSidecar(name string) bool {
+	return len(name) > len(sidecarPrefix+sidecarSuffix) && strings.HasPrefix(name, sidecarPrefix) && strings.HasSuffix(name, sidecarSuffix)
+}
+
+// readSidecar returns the sha256 of the sidecar of the file
+func readSidecar(c *sftp.Client, p string) (string, error) {
+	f, err := c.Open(sidecarPath(p))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var body strings.Builder
+	_, err = f.WriteTo(&body)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(body.String())
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
@@ -0,0 +1,259 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/loqutus/artifactory-replication/pkg/credentials"
+	"github.com/loqutus/artifactory-replication/pkg/sha256"
+	"github.com/loqutus/artifactory-replication/pkg/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an in-process SSH server with the SFTP subsystem, serving the local files
+type testServer struct {
+	listener   net.Listener
+	dir        string
+	knownHosts string
+}
+
+// newTestServer starts a server accepting the user "replication" with the password "secret",
+// with a known hosts file holding its key
+func newTestServer(t *testing.T) *testServer {
+	dir, err := ioutil.TempDir("", "sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if c.User() != "replication" || string(password) != "secret" {
+			return nil, ssh.ErrNoAuth
+		}
+		return nil, nil
+	}}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: listener, dir: dir, knownHosts: filepath.Join(dir, "known_hosts")}
+	line := knownhosts.Line([]string{listener.Addr().String()}, signer.PublicKey())
+	err = ioutil.WriteFile(s.knownHosts, []byte(line+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "root"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(c, config)
+		}
+	}()
+	return s
+}
+
+func serveConn(c net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// the payload is the length prefixed subsystem name
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+	os.RemoveAll(s.dir)
+}
+
+// storage returns the storage of the root directory of the server
+func (s *testServer) storage() *Storage {
+	creds := credentials.Creds{DestinationUser: "replication", DestinationPassword: "secret"}
+	return NewStorage(s.listener.Addr().String()+filepath.ToSlash(filepath.Join(s.dir, "root")), creds, s.knownHosts)
+}
+
+// put uploads the data to the key from a local file
+func (s *testServer) put(t *testing.T, st *Storage, key string, data string) string {
+	path := filepath.Join(s.dir, "upload")
+	err := ioutil.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Put(key, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSHA256, err := sha256.ComputeFileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileSHA256
+}
+
+// files returns the names of the files of the directory of the server
+func (s *testServer) files(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, "root", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestPut(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	st := s.storage()
+	s.put(t, st, "charts/index.yaml", "old")
+	fileSHA256 := s.put(t, st, "charts/index.yaml", "entries: {}\n")
+	body, err := ioutil.ReadFile(filepath.Join(s.dir, "root", "charts", "index.yaml"))
+	if err != nil || string(body) != "entries: {}\n" {
+		t.Errorf("charts/index.yaml holds %q, %v, expected the second upload", body, err)
+	}
+	sidecar, err := ioutil.ReadFile(filepath.Join(s.dir, "root", "charts", ".index.yaml.sha256sum"))
+	if err != nil || string(sidecar) != fileSHA256+"  index.yaml\n" {
+		t.Errorf("sidecar holds %q, %v, expected the sha256sum line of index.yaml", sidecar, err)
+	}
+	// the temp files are renamed
+	if names := s.files(t, "charts"); strings.Join(names, ",") != ".index.yaml.sha256sum,index.yaml" {
+		t.Errorf("charts holds %v, expected index.yaml and its sidecar", names)
+	}
+	sha, err := st.SHA256("charts/index.yaml")
+	if err != nil || sha != fileSHA256 {
+		t.Errorf("SHA256 returned %q, %v, expected %q", sha, err, fileSHA256)
+	}
+	_, err = st.Stat("charts/missing")
+	if err != storage.ErrNotFound {
+		t.Errorf("Stat of a missing file returned %v, expected ErrNotFound", err)
+	}
+}
+
+func TestPutRepoRoot(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	// without a root the paths are relative to the login directory, the working directory of the test server
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(filepath.Join(s.dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	st := s.storage()
+	st.Root = ""
+	if p := st.path("/index.yaml"); p != "index.yaml" {
+		t.Errorf("path of /index.yaml is %q, expected index.yaml", p)
+	}
+	s.put(t, st, "/index.yaml", "entries: {}\n")
+	body, err := ioutil.ReadFile(filepath.Join(s.dir, "root", "index.yaml"))
+	if err != nil || string(body) != "entries: {}\n" {
+		t.Errorf("index.yaml holds %q, %v, expected the upload", body, err)
+	}
+	sha, err := st.SHA256("index.yaml")
+	if err != nil || sha == "" {
+		t.Errorf("SHA256 of the repo root file returned %q, %v", sha, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	st := s.storage()
+	s.put(t, st, "charts/index.yaml", "entries: {}\n")
+	s.put(t, st, "charts/index.yaml.sha256", "abc\n")
+	s.put(t, st, "charts/nested/a-1.0.0.tgz", "a")
+	s.put(t, st, "other/b", "b")
+	// a temp file left by an interrupted upload
+	err := ioutil.WriteFile(filepath.Join(s.dir, "root", "charts", tempPrefix+"a-1"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := st.List("charts/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := storage.Index(objects)
+	for _, key := range []string{"charts/index.yaml", "charts/index.yaml.sha256", "charts/nested/a-1.0.0.tgz"} {
+		if _, ok := index[key]; !ok {
+			t.Errorf("%s not listed", key)
+		}
+	}
+	if len(objects) != 3 {
+		t.Errorf("listed %v, expected 3 files", objects)
+	}
+	if o := index["charts/index.yaml.sha256"]; o.Size != 4 {
+		t.Errorf("wrong object %+v", o)
+	}
+	objects, err = st.List("missing/")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("listing a missing directory returned %v, %v", objects, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	st := s.storage()
+	s.put(t, st, "charts/index.yaml", "entries: {}\n")
+	s.put(t, st, "charts/index.yaml.sha256", "abc\n")
+	err := st.Delete("charts/index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := s.files(t, "charts"); strings.Join(names, ",") != ".index.yaml.sha256.sha256sum,index.yaml.sha256" {
+		t.Errorf("charts holds %v after removing index.yaml, expected index.yaml.sha256 and its sidecar", names)
+	}
+	err = st.Delete("charts/index.yaml")
+	if err != nil {
+		t.Errorf("removing a removed file returned %v", err)
+	}
+}
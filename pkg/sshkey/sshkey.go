@@ -0,0 +1,49 @@
+package sshkey
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// IsPrivateKey tells whether the value is a PEM private key rather than a password or a path
+func IsPrivateKey(value string) bool {
+	return strings.Contains(value, "PRIVATE KEY-----")
+}
+
+// Signer parses the PEM private key, or reads it from the file if key is a path
+func Signer(key string) (ssh.Signer, error) {
+	pem := []byte(key)
+	if !IsPrivateKey(key) {
+		var err error
+		pem, err = ioutil.ReadFile(key)
+		if err != nil {
+			return nil, errors.New("reading private key: " + err.Error())
+		}
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, errors.New("parsing private key: " + err.Error())
+	}
+	return signer, nil
+}
+
+// HostKeyCallback verifies host keys against the known hosts file, ~/.ssh/known_hosts if empty
+func HostKeyCallback(knownHosts string) (ssh.HostKeyCallback, error) {
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if _, err := ioutil.ReadFile(knownHosts); err != nil {
+		return nil, errors.New("reading known hosts: " + err.Error())
+	}
+	return knownhosts.New(knownHosts)
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Stat and SHA256 when the object doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// ErrNoChecksum is returned by SHA256 when the object exists without a stored checksum
+var ErrNoChecksum = errors.New("missing sha256")
+
+// Object is a file stored in a bucket or repo
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+	// SHA256 is the hex checksum, empty when the listing doesn't return it
+	SHA256 string
+}
+
+// Storage is a destination of binary artifacts. Keys are slash separated paths relative to the bucket or repo root,
+// without a leading slash, e.g. charts/helm/nginx-1.0.0.tgz. Callers pass keys in this form, storages don't normalize
+// them, so a key with a leading slash would name another object than the one listed.
+type Storage interface {
+	// List returns every object under the prefix, recursively, the whole bucket when prefix is empty
+	List(prefix string) ([]Object, error)
+	// Stat returns the object size, modification time and checksum, or ErrNotFound
+	Stat(key string) (Object, error)
+	// Get downloads the object to the local file path
+	Get(key string, path string) error
+	// Put uploads the local file, storing its sha256 checksum with it
+	Put(key string, path string) error
+	// Delete removes the object
+	Delete(key string) error
+	// SHA256 returns the checksum stored with the object
+	SHA256(key string) (string, error)
+}
+
+// Index returns the objects by key
+func Index(objects []Object) map[string]Object {
+	index := make(map[string]Object, len(objects))
+	for _, o := range objects {
+		index[o.Key] = o
+	}
+	return index
+}
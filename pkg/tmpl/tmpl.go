@@ -0,0 +1,19 @@
+package tmpl
+
+import (
+	"encoding/json"
+	"text/template"
+)
+
+// Funcs are the funcs of the webhook templates: json encodes a value, e.g. {"text": {{json .Title}}}
+var Funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Parse parses the text/template with the funcs, for both the config validation and the notifiers
+func Parse(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(Funcs).Parse(text)
+}
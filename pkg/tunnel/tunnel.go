@@ -0,0 +1,300 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/httpclient"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/retry"
+	"github.com/loqutus/artifactory-replication/pkg/sshkey"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepAliveInterval is how often the jump host connection is checked, it is reopened when the check fails
+const keepAliveInterval = 30 * time.Second
+
+// Tunnel is an SSH connection to a jump host, reopened when it is lost, that connections are dialed through
+type Tunnel struct {
+	config    config.Tunnel
+	sshConfig *ssh.ClientConfig
+	address   string
+	direct    net.Dialer
+	// sources are the source hosts of the jobs, host or host:port, dialed through the jump host without a destination
+	sources map[string]bool
+
+	mu     sync.Mutex
+	client *ssh.Client
+	// reconnecting is closed when the reconnect in progress ends, nil if there is none, and reconnectErr is its error
+	reconnecting chan struct{}
+	reconnectErr error
+
+	listener net.Listener
+	done     chan struct{}
+}
+
+// Start connects to the jump host of the config and makes the HTTP clients using the default transport, which include
+// the artifactory and docker registry clients, dial the tunnel destination through it, or the source hosts of the jobs
+// if there is no destination. If the local port is set, it is also forwarded to the destination.
+func Start(conf *config.Config) (*Tunnel, error) {
+	c := conf.Tunnel
+	signer, err := sshkey.Signer(c.Key)
+	if err != nil {
+		return nil, errors.New("jump host key: " + err.Error())
+	}
+	hostKeyCallback, err := sshkey.HostKeyCallback(c.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	address := c.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+	t := &Tunnel{
+		config: c,
+		sshConfig: &ssh.ClientConfig{
+			User:            c.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		address: address,
+		sources: make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	for _, j := range conf.Jobs {
+		for _, r := range []config.Registry{j.Source, j.Clean.Prod} {
+			if r.Registry != "" {
+				t.sources[strings.ToLower(strings.SplitN(r.Registry, "/", 2)[0])] = true
+			}
+		}
+	}
+	_, err = t.connect(nil)
+	if err != nil {
+		return nil, err
+	}
+	go t.keepAlive()
+	if c.LocalPort != 0 {
+		err = t.forward()
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	httpclient.SetDialContext(t.DialContext)
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.DialContext = t.DialContext
+	}
+	if c.Destination != "" {
+		logger.Info("Connecting through jump host", "jump_host", address, "destination", c.Destination)
+	} else {
+		logger.Info("Connecting through jump host", "jump_host", address, "destination", strings.Join(t.sourceList(), ","))
+	}
+	return t, nil
+}
+
+// connect returns the current SSH client, or opens a new one, retrying, if it is the broken one.
+// A single reconnect runs at a time, without holding the lock, and the other callers wait for its result.
+func (t *Tunnel) connect(broken *ssh.Client) (*ssh.Client, error) {
+	for {
+		t.mu.Lock()
+		if t.client != nil && t.client != broken {
+			client := t.client
+			t.mu.Unlock()
+			return client, nil
+		}
+		if wait := t.reconnecting; wait != nil {
+			t.mu.Unlock()
+			<-wait
+			t.mu.Lock()
+			err := t.reconnectErr
+			t.mu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		old := t.client
+		t.client = nil
+		wait := make(chan struct{})
+		t.reconnecting = wait
+		t.mu.Unlock()
+		if old != nil {
+			old.Close()
+		}
+		var client *ssh.Client
+		err := retry.Do(context.Background(), "connecting to jump host "+t.address, func() error {
+			c, err := ssh.Dial("tcp", t.address, t.sshConfig)
+			if err != nil {
+				return err
+			}
+			client = c
+			return nil
+		})
+		t.mu.Lock()
+		t.client, t.reconnectErr, t.reconnecting = client, err, nil
+		t.mu.Unlock()
+		close(wait)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+func (t *Tunnel) current() *ssh.Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.client
+}
+
+// keepAlive sends keepalive requests to the jump host, and reconnects when they fail
+func (t *Tunnel) keepAlive() {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		client := t.current()
+		if client != nil && sendKeepAlive(client) == nil {
+			continue
+		}
+		logger.Warn("Jump host connection lost, reconnecting", "jump_host", t.address)
+		_, err := t.connect(client)
+		if err != nil {
+			logger.Error("Error reconnecting to jump host", "jump_host", t.address, "err", err)
+		}
+	}
+}
+
+// sendKeepAlive fails if the jump host doesn't answer within the keepalive interval
+func sendKeepAlive(client *ssh.Client) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(keepAliveInterval):
+		return errors.New("keepalive timeout")
+	}
+}
+
+// routed tells if the address is dialed through the jump host: the tunnel destination, or if there is none,
+// a source host of the jobs
+func (t *Tunnel) routed(address string) bool {
+	if t.config.Destination != "" {
+		return address == t.config.Destination
+	}
+	address = strings.ToLower(address)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return t.sources[address] || t.sources[host]
+}
+
+func (t *Tunnel) sourceList() []string {
+	var list []string
+	for host := range t.sources {
+		list = append(list, host)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// DialContext dials the address through the jump host if it is routed, and directly otherwise, e.g. the buckets,
+// Slack or the Pushgateway. A dial failing on a lost connection is retried once on a new one.
+func (t *Tunnel) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if !t.routed(address) {
+		return t.direct.DialContext(ctx, network, address)
+	}
+	client := t.current()
+	if client != nil {
+		conn, err := client.Dial(network, address)
+		if err == nil {
+			return conn, nil
+		}
+		logger.Warn("Error dialing through jump host", "jump_host", t.address, "address", address, "err", err)
+	}
+	client, err := t.connect(client)
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, address)
+}
+
+// forward listens on the local port and copies every connection to the destination through the jump host
+func (t *Tunnel) forward() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(t.config.LocalPort)))
+	if err != nil {
+		return err
+	}
+	t.listener = listener
+	logger.Info("Forwarding through jump host", "jump_host", t.address, "listen", listener.Addr().String(), "destination", t.config.Destination)
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-t.done:
+				default:
+					logger.Error("Error accepting forwarded connection", "err", err)
+				}
+				return
+			}
+			go t.pipe(local)
+		}
+	}()
+	return nil
+}
+
+func (t *Tunnel) pipe(local net.Conn) {
+	defer local.Close()
+	remote, err := t.DialContext(context.Background(), "tcp", t.config.Destination)
+	if err != nil {
+		logger.Error("Error dialing through jump host", "jump_host", t.address, "destination", t.config.Destination, "err", err)
+		return
+	}
+	defer remote.Close()
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// Close stops the forwarding and the keepalives and closes the jump host connection
+func (t *Tunnel) Close() error {
+	close(t.done)
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
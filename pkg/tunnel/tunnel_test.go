@@ -0,0 +1,28 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+)
+
+func TestRouted(t *testing.T) {
+	sources := map[string]bool{"artifactory.example.com": true, "registry.example.com:5000": true}
+	tunnel := &Tunnel{sources: sources}
+	for address, expected := range map[string]bool{
+		"artifactory.example.com:443": true,
+		"Artifactory.example.com:80":  true,
+		"registry.example.com:5000":   true,
+		"registry.example.com:443":    false,
+		"storage.googleapis.com:443":  false,
+		"hooks.slack.com:443":         false,
+	} {
+		if routed := tunnel.routed(address); routed != expected {
+			t.Errorf("routed(%q) = %v without a destination, expected %v", address, routed, expected)
+		}
+	}
+	tunnel = &Tunnel{config: config.Tunnel{Destination: "bastion.internal:443"}, sources: sources}
+	if !tunnel.routed("bastion.internal:443") || tunnel.routed("artifactory.example.com:443") {
+		t.Errorf("only the destination should be routed when it is set")
+	}
+}
@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/loqutus/artifactory-replication/pkg/config"
+	"github.com/loqutus/artifactory-replication/pkg/logger"
+	"github.com/loqutus/artifactory-replication/pkg/scheduler"
+)
+
+// maxBody limits the payloads read, a registry envelope holds a few events
+const maxBody = 10 << 20
+
+// Handler receives the events of the sources and queues a run of every job replicating the artifact, narrowed to it:
+//
+//	POST /hooks/artifactory  Artifactory webhooks of the artifact and docker domains
+//	POST /hooks/registry     Docker Distribution notification envelopes
+//
+// Requests are verified with the shared secret: one of the X-JFrog-Event-Auth, X-Hub-Signature-256
+// or Authorization: Bearer headers must hold the secret or the hex HMAC-SHA256 of the body with the secret.
+func Handler(s *scheduler.Scheduler, c *config.Config, secret string) http.Handler {
+	h := &receiver{scheduler: s, config: c, secret: secret}
+	mux := http.NewServeMux()
+	mux.Handle("/hooks/artifactory", h.handle(h.artifactory))
+	mux.Handle("/hooks/registry", h.handle(h.registry))
+	return mux
+}
+
+type receiver struct {
+	scheduler *scheduler.Scheduler
+	config    *config.Config
+	secret    string
+}
+
+// queued is a run queued for an event
+type queued struct {
+	Job string `json:"job"`
+	scheduler.Request
+}
+
+// handle verifies the request, then queues the runs parse returns for its body
+func (h *receiver) handle(parse func(body []byte) ([]queued, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method " + r.Method + " not allowed, expected POST"})
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if !h.verify(r.Header, body) {
+			logger.Warn("Webhook with a missing or wrong signature", "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong signature"})
+			return
+		}
+		runs, err := parse(body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "wrong payload: " + err.Error()})
+			return
+		}
+		list := []queued{}
+		for _, q := range runs {
+			err := h.scheduler.Enqueue(q.Job, q.Request)
+			if errors.Is(err, scheduler.ErrStopped) {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				logger.Warn("Event run not queued", "job", q.Job, "filter", q.Filter, "repo", q.Repo, "tag", q.Tag, "err", err)
+				continue
+			}
+			logger.Info("Event run queued", "job", q.Job, "filter", q.Filter, "repo", q.Repo, "tag", q.Tag)
+			list = append(list, q)
+		}
+		// events of no job are accepted too, so that the source doesn't send them again
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"queued": list})
+	})
+}
+
+// verify tells whether a signature header holds the secret or the HMAC of the body
+func (h *receiver) verify(header http.Header, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+	for _, value := range []string{
+		header.Get("X-JFrog-Event-Auth"),
+		strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="),
+		strings.TrimPrefix(header.Get("Authorization"), "Bearer "),
+	} {
+		if value == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(value), []byte(h.secret)) == 1 ||
+			subtle.ConstantTimeCompare([]byte(strings.ToLower(value)), []byte(signature)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// artifactoryEvent holds the fields of the Artifactory webhook payloads used here
+type artifactoryEvent struct {
+	Domain    string `json:"domain"`
+	EventType string `json:"event_type"`
+	// Origin is the Artifactory URL, e.g. https://artifactory.example.com
+	Origin string `json:"jpd_origin"`
+	Data   struct {
+		RepoKey string `json:"repo_key"`
+		Path    string `json:"path"`
+		// TargetRepoPath is where a file is copied or moved to, repo/path
+		TargetRepoPath string `json:"target_repo_path"`
+		ImageName      string `json:"image_name"`
+		Tag            string `json:"tag"`
+	} `json:"data"`
+}
+
+// artifactory queues a run for deployed, copied or moved files, and pushed or promoted docker tags.
+// Deletions are left to the cleanups.
+func (h *receiver) artifactory(body []byte) ([]queued, error) {
+	var e artifactoryEvent
+	err := json.Unmarshal(body, &e)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case e.Domain == "artifact" && (e.EventType == "deployed" || e.EventType == "copied" || e.EventType == "moved"):
+		file := e.Data.RepoKey + "/" + strings.Trim(e.Data.Path, "/")
+		if e.Data.TargetRepoPath != "" {
+			file = strings.Trim(e.Data.TargetRepoPath, "/")
+		} else if e.Data.RepoKey == "" || e.Data.Path == "" {
+			return nil, errors.New("artifact event without repo_key or path")
+		}
+		return h.binaryRuns(originHost(e.Origin), file), nil
+	case e.Domain == "docker" && (e.EventType == "pushed" || e.EventType == "promoted"):
+		if e.Data.ImageName == "" || e.Data.Tag == "" {
+			return nil, errors.New("docker event without image_name or tag")
+		}
+		return h.dockerRuns("", e.Data.ImageName, e.Data.Tag), nil
+	}
+	logger.Debug("Artifactory event ignored", "domain", e.Domain, "event_type", e.EventType)
+	return nil, nil
+}
+
+// registryEnvelope holds the fields of the Docker Distribution notifications used here
+type registryEnvelope struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// registry queues a run for every tag pushed. Pulls, mounts, blob pushes and deletions are ignored.
+func (h *receiver) registry(body []byte) ([]queued, error) {
+	var envelope registryEnvelope
+	err := json.Unmarshal(body, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	var runs []queued
+	for _, e := range envelope.Events {
+		if e.Action != "push" || e.Target.Tag == "" || e.Target.Repository == "" {
+			continue
+		}
+		runs = append(runs, h.dockerRuns(e.Request.Host, e.Target.Repository, e.Target.Tag)...)
+	}
+	return runs, nil
+}
+
+// binaryRuns returns the runs of the binary jobs replicating the file, narrowed to its directory
+func (h *receiver) binaryRuns(host string, file string) []queued {
+	var runs []queued
+	for _, j := range h.config.Jobs {
+		if j.Type != "binary" || j.Check || j.Clean.Enabled || host != "" && !sameHost(host, j.Source.Registry) {
+			continue
+		}
+		for _, filter := range []string{j.Filter, j.FilterProd} {
+			filter = strings.Trim(filter, "/")
+			if filter == "" || !strings.HasPrefix(file, filter+"/") {
+				continue
+			}
+			dir := path.Dir(file)
+			if len(dir) < len(filter) {
+				dir = filter
+			}
+			runs = append(runs, queued{Job: j.Name, Request: scheduler.Request{Kind: scheduler.KindRun, Filter: dir}})
+			break
+		}
+	}
+	return runs
+}
+
+// dockerRuns returns the runs of the docker jobs replicating the tag, narrowed to exactly its repo and to its tag
+func (h *receiver) dockerRuns(host string, repo string, tag string) []queued {
+	var runs []queued
+	for _, j := range h.config.Jobs {
+		if j.Type != "docker" || j.Check || j.Clean.Enabled || host != "" && !sameHost(host, j.Source.Registry) {
+			continue
+		}
+		if !strings.HasPrefix(repo, j.Filter) {
+			continue
+		}
+		if j.Docker.Tag != "" && j.Docker.Tag != tag {
+			continue
+		}
+		runs = append(runs, queued{Job: j.Name, Request: scheduler.Request{Kind: scheduler.KindRun, Repo: repo, Tag: tag}})
+	}
+	return runs
+}
+
+// originHost returns the host of the Artifactory URL, or an empty string
+func originHost(origin string) string {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// sameHost tells whether the event host is the registry, ignoring the default ports
+func sameHost(host string, registry string) bool {
+	trim := func(h string) string {
+		h = strings.ToLower(h)
+		return strings.TrimSuffix(strings.TrimSuffix(h, ":443"), ":80")
+	}
+	return trim(host) == trim(registry)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}